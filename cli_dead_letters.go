@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const deadLetterCommandUsage = `Usage: ./program [--config path] [--admin-url url] dead-letters <command>
+
+Commands:
+  list           List the dead letters.
+  show <id>      Print a dead letter.
+  replay <id>    Replay a dead letter with its handler.
+  delete <id>    Discard a dead letter.
+
+The chatbot must be running with "admin-path" and "admin-token" configured.`
+
+// # Dead-letter Command
+//
+// Manage the dead letters of a running chatbot through its admin endpoints.
+// The admin URL defaults to the local address of the chatbot.
+func runDeadLetterCommand(config ServerConfig, adminUrl string, args []string) error {
+	admin_url, err := adminBaseUrl(config, adminUrl)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fmt.Println(deadLetterCommandUsage)
+		return nil
+	}
+	base_url := admin_url + "/dead-letters"
+
+	command, id := args[0], ""
+	if command != "list" {
+		if len(args) != 2 {
+			return fmt.Errorf("command %q requires a dead letter ID\n\n%s", command, deadLetterCommandUsage)
+		}
+		id = args[1]
+	}
+
+	switch command {
+	case "list":
+		var letters []DeadLetter
+		if err := adminRequest(config.AdminToken, "GET", base_url, &letters); err != nil {
+			return err
+		}
+		printDeadLetters(letters)
+
+	case "show":
+		var letter DeadLetter
+		if err := adminRequest(config.AdminToken, "GET", base_url+"/"+id, &letter); err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(letter)
+
+	case "replay":
+		if err := adminRequest(config.AdminToken, "POST", base_url+"/"+id+"/replay", nil); err != nil {
+			return err
+		}
+		fmt.Printf("Dead letter %s is being replayed, check the logs or list the dead letters for the result.\n", id)
+
+	case "delete":
+		if err := adminRequest(config.AdminToken, "DELETE", base_url+"/"+id, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Dead letter %s deleted.\n", id)
+
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, deadLetterCommandUsage)
+	}
+	return nil
+}
+
+// Get the base URL of the admin endpoints, defaults to the local address of the chatbot.
+func adminBaseUrl(config ServerConfig, adminUrl string) (string, error) {
+	if config.AdminPath == "" || config.AdminToken == "" {
+		return "", errors.New("admin endpoints are not configured, set \"admin-path\" and \"admin-token\"")
+	}
+	if adminUrl == "" {
+		host := config.Address
+		if host == "" || host == "0.0.0.0" {
+			host = "127.0.0.1"
+		}
+		adminUrl = fmt.Sprintf("http://%s:%d%s", host, config.Port, config.AdminPath)
+	}
+	return strings.TrimSuffix(adminUrl, "/"), nil
+}
+
+// Send a request to an admin endpoint, and decode the JSON response into `result` if not nil.
+func adminRequest(token string, method string, url string, result any) error {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Print the dead letters as a table.
+func printDeadLetters(letters []DeadLetter) {
+	if len(letters) == 0 {
+		fmt.Println("No dead letters.")
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tFAILED AT\tHANDLER\tCHANNEL\tUSER\tATTEMPTS\tREPLAYS\tERROR")
+	for _, letter := range letters {
+		error_message := letter.Error
+		if runes := []rune(error_message); len(runes) > 60 {
+			error_message = string(runes[:60]) + "..."
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%s\t%d\t%d\t%s\n",
+			letter.Id, letter.FailedAt.Format(time.DateTime), letter.Handler, letter.Event.Destination,
+			letter.Event.Source.UserId, letter.Attempts, letter.Replays, error_message)
+	}
+	writer.Flush()
+}
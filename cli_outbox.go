@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+const outboxCommandUsage = `Usage: ./program [--config path] [--admin-url url] outbox <command>
+
+Commands:
+  list [status]  List the outbound messages, optionally of a status: pending, delivered or failed.
+  show <id>      Print an outbound message and its delivery status.
+
+The chatbot must be running with "admin-path" and "admin-token" configured.`
+
+// # Outbox Command
+//
+// Query the delivery status of the outbound messages of a running chatbot through its admin endpoints.
+func runOutboxCommand(config ServerConfig, adminUrl string, args []string) error {
+	admin_url, err := adminBaseUrl(config, adminUrl)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fmt.Println(outboxCommandUsage)
+		return nil
+	}
+	base_url := admin_url + "/outbox"
+
+	switch {
+	case args[0] == "list" && len(args) <= 2:
+		query := ""
+		if len(args) == 2 {
+			query = "?status=" + url.QueryEscape(args[1])
+		}
+		var messages []OutboxMessage
+		if err := adminRequest(config.AdminToken, "GET", base_url+query, &messages); err != nil {
+			return err
+		}
+		printOutboxMessages(messages)
+
+	case args[0] == "show" && len(args) == 2:
+		var message OutboxMessage
+		if err := adminRequest(config.AdminToken, "GET", base_url+"/"+args[1], &message); err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(message)
+
+	default:
+		return fmt.Errorf("invalid command %q\n\n%s", args, outboxCommandUsage)
+	}
+	return nil
+}
+
+// Print the outbound messages as a table.
+func printOutboxMessages(messages []OutboxMessage) {
+	if len(messages) == 0 {
+		fmt.Println("No outbound messages.")
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tCREATED AT\tSTATUS\tCHANNEL\tRECIPIENT\tATTEMPTS\tNEXT ATTEMPT\tERROR")
+	for _, message := range messages {
+		recipient := message.Payload.Recipient
+		if recipient == "" {
+			recipient = "(broadcast)"
+		}
+		next_attempt := "-"
+		if message.Status == OutboxPending {
+			next_attempt = message.NextAttemptAt.Format(time.DateTime)
+		}
+		error_message := message.Error
+		if runes := []rune(error_message); len(runes) > 60 {
+			error_message = string(runes[:60]) + "..."
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%s\t%d\t%s\t%s\n",
+			message.Id, message.CreatedAt.Format(time.DateTime), message.Status, message.Channel,
+			recipient, message.Attempts, next_attempt, error_message)
+	}
+	writer.Flush()
+}
@@ -1 +1,133 @@
 package taipeion_core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of send failures, match them with `errors.Is`.
+var (
+	ErrSendAuth            = errors.New("taipeion rejected the credentials")
+	ErrSendRateLimited     = errors.New("taipeion rate limit exceeded")
+	ErrSendPayloadRejected = errors.New("taipeion rejected the payload")
+	ErrSendServerError     = errors.New("taipeion server error")
+)
+
+// # Send Response
+//
+// The response of TaipeiON to an outbound message.
+type SendResponse struct {
+	StatusCode int           `json:"-"`       // HTTP status of the response, decides whether the message was accepted.
+	Code       string        `json:"code"`    // Error code reported in the body, if any.
+	Message    string        `json:"message"` // Message reported in the body, if any.
+	Body       string        `json:"-"`       // The raw body.
+	RetryAfter time.Duration `json:"-"`       // Wait requested by the `Retry-After` header, zero if none.
+}
+
+// Whether TaipeiON accepted the message.
+func (r SendResponse) Success() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// # Send Error
+//
+// A message rejected by TaipeiON. `Kind` is one of the `ErrSend*` errors.
+type SendError struct {
+	Kind     error
+	Response SendResponse
+}
+
+func (e *SendError) Error() string {
+	detail := e.Response.Message
+	if detail == "" {
+		detail = e.Response.Body
+	}
+	if e.Response.Code != "" {
+		detail = fmt.Sprintf("code %s: %s", e.Response.Code, detail)
+	}
+	return fmt.Sprintf("%s (status %d): %s", e.Kind, e.Response.StatusCode, detail)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Kind
+}
+
+// Whether sending the same message again later may succeed.
+func (e *SendError) Retryable() bool {
+	return e.Kind == ErrSendRateLimited || e.Kind == ErrSendServerError
+}
+
+// # Is Retryable Send Error
+//
+// Whether the error is a send failure which may succeed on retry. Errors other than `SendError`,
+// e.g. network errors, are not known to TaipeiON and are left to the caller.
+func IsRetryableSendError(err error) bool {
+	var send_err *SendError
+	return errors.As(err, &send_err) && send_err.Retryable()
+}
+
+// # Parse Send Response
+//
+// Parse the response of TaipeiON to an outbound message. The body is optional JSON carrying
+// `code` and `message`; the code may be a number or a string.
+// A `SendError` is returned along with the response if the message was not accepted.
+func ParseSendResponse(statusCode int, header http.Header, body []byte) (SendResponse, error) {
+	response := SendResponse{StatusCode: statusCode, Body: string(body)}
+	response.RetryAfter = ParseRetryAfter(header.Get("Retry-After"), time.Now())
+
+	var fields struct {
+		Code    any    `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &fields) == nil {
+		response.Message = fields.Message
+		switch code := fields.Code.(type) {
+		case nil:
+		case float64:
+			response.Code = fmt.Sprintf("%.0f", code)
+		default:
+			response.Code = fmt.Sprint(code)
+		}
+	}
+
+	if response.Success() {
+		return response, nil
+	}
+	return response, &SendError{Kind: classifySendFailure(response), Response: response}
+}
+
+// Map a failed response to the kind of the failure.
+func classifySendFailure(response SendResponse) error {
+	switch status := response.StatusCode; {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrSendAuth
+	case status == http.StatusTooManyRequests:
+		return ErrSendRateLimited
+	case status >= 500:
+		return ErrSendServerError
+	}
+	return ErrSendPayloadRejected // Other client errors, sending the same message again would fail again.
+}
+
+// # Parse Retry-After
+//
+// Parse the `Retry-After` header, either delay seconds or an HTTP date.
+// Returns zero if the header is empty, malformed or in the past.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package taipeion_core
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseSendResponse(t *testing.T) {
+	cases := []struct {
+		status    int
+		body      string
+		kind      error
+		retryable bool
+	}{
+		{200, `{"status":"ok"}`, nil, false},
+		{204, ``, nil, false},
+		{401, `{"message":"invalid token"}`, ErrSendAuth, false},
+		{429, `{"code":429,"message":"too many requests"}`, ErrSendRateLimited, true},
+		{404, `{"message":"not found"}`, ErrSendPayloadRejected, false},
+		{400, `{"code":"E1001","message":"text too long"}`, ErrSendPayloadRejected, false},
+		{502, `<html>Bad Gateway</html>`, ErrSendServerError, true},
+	}
+
+	for _, c := range cases {
+		response, err := ParseSendResponse(c.status, nil, []byte(c.body))
+		if response.StatusCode != c.status || response.Body != c.body {
+			t.Errorf("%d: expected the status and the body to be kept, got %#v", c.status, response)
+		}
+		if c.kind == nil {
+			if err != nil || !response.Success() {
+				t.Errorf("%d: expected success, got %v", c.status, err)
+			}
+			continue
+		}
+
+		var send_err *SendError
+		if !errors.As(err, &send_err) || !errors.Is(err, c.kind) {
+			t.Errorf("%d: expected %v, got %v", c.status, c.kind, err)
+			continue
+		}
+		if IsRetryableSendError(err) != c.retryable {
+			t.Errorf("%d: expected retryable to be %t", c.status, c.retryable)
+		}
+	}
+
+	header := http.Header{"Retry-After": []string{"30"}}
+	response, _ := ParseSendResponse(429, header, []byte(`{"code":429,"message":"too many requests"}`))
+	if response.Code != "429" || response.Message != "too many requests" || response.RetryAfter != 30*time.Second {
+		t.Errorf("Expected the code, the message and the wait to be parsed, got %#v", response)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"-5":                            0,
+		"Tue, 01 Oct 2024 12:01:30 GMT": 90 * time.Second,
+		"Tue, 01 Oct 2024 11:00:00 GMT": 0,
+		"soon":                          0,
+	}
+	for value, expected := range cases {
+		if got := ParseRetryAfter(value, now); got != expected {
+			t.Errorf("%q: expected %s, got %s", value, expected, got)
+		}
+	}
+}
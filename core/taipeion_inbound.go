@@ -0,0 +1,82 @@
+package taipeion_core
+
+// Inbound only message types.
+const (
+	MessageTypeLocation = "location"
+)
+
+// # Inbound Message Content
+//
+// The typed content of a received message, use a type switch to handle each type:
+//
+//	switch content := event.Message.Content().(type) {
+//	case tp.ImageContent:
+//	case tp.LocationContent:
+//	}
+type InboundContent interface {
+	MessageId() string
+}
+
+// A text message.
+type TextContent struct {
+	Id   string
+	Text string
+}
+
+// An image message.
+type ImageContent struct {
+	Id string
+}
+
+// A file message.
+type FileContent struct {
+	Id       string
+	FileName string
+	FileSize int64
+}
+
+// A sticker message.
+type StickerContent struct {
+	Id        string
+	PackageId string
+	StickerId string
+}
+
+// A location message.
+type LocationContent struct {
+	Id        string
+	Title     string
+	Address   string
+	Latitude  float64
+	Longitude float64
+}
+
+// A message of a type not modelled yet, the raw message is kept.
+type UnknownContent struct {
+	Message Message
+}
+
+func (c TextContent) MessageId() string     { return c.Id }
+func (c ImageContent) MessageId() string    { return c.Id }
+func (c FileContent) MessageId() string     { return c.Id }
+func (c StickerContent) MessageId() string  { return c.Id }
+func (c LocationContent) MessageId() string { return c.Id }
+func (c UnknownContent) MessageId() string  { return c.Message.Id }
+
+// # Typed Content of a Received Message
+func (m Message) Content() InboundContent {
+	switch m.Type {
+	case MessageTypeText:
+		return TextContent{Id: m.Id, Text: m.Text}
+	case MessageTypeImage:
+		return ImageContent{Id: m.Id}
+	case MessageTypeFile:
+		return FileContent{Id: m.Id, FileName: m.FileName, FileSize: m.FileSize}
+	case MessageTypeSticker:
+		return StickerContent{Id: m.Id, PackageId: m.PackageId, StickerId: m.StickerId}
+	case MessageTypeLocation:
+		return LocationContent{Id: m.Id, Title: m.Title, Address: m.Address, Latitude: m.Latitude, Longitude: m.Longitude}
+	default:
+		return UnknownContent{Message: m}
+	}
+}
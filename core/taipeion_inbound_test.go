@@ -0,0 +1,50 @@
+package taipeion_core
+
+import (
+	"testing"
+)
+
+func TestInboundMessageContent(t *testing.T) {
+	payload, err := DeserializeWebhookMessage([]byte(`{"destination":1,"events":[
+		{"type":"message","timestamp":1,"source":{"type":"user","userId":"u"},"message":{"type":"image","id":"m1"}},
+		{"type":"message","timestamp":2,"source":{"type":"user","userId":"u"},"message":{"type":"file","id":"m2","fileName":"form.pdf","fileSize":2048}},
+		{"type":"message","timestamp":3,"source":{"type":"user","userId":"u"},"message":{"type":"sticker","id":"m3","packageId":"1","stickerId":"2"}},
+		{"type":"message","timestamp":4,"source":{"type":"user","userId":"u"},"message":{"type":"location","id":"m4","title":"City Hall","address":"No. 1, Shifu Rd.","latitude":25.0375,"longitude":121.5637}},
+		{"type":"message","timestamp":5,"source":{"type":"user","userId":"u"},"message":{"type":"video","id":"m5"}}
+	]}`))
+	if err != nil {
+		t.Fatalf("Error deserializing payload: %v", err)
+	}
+
+	expected := []InboundContent{
+		ImageContent{Id: "m1"},
+		FileContent{Id: "m2", FileName: "form.pdf", FileSize: 2048},
+		StickerContent{Id: "m3", PackageId: "1", StickerId: "2"},
+		LocationContent{Id: "m4", Title: "City Hall", Address: "No. 1, Shifu Rd.", Latitude: 25.0375, Longitude: 121.5637},
+		UnknownContent{Message: Message{Type: "video", Id: "m5"}},
+	}
+
+	for i, event := range payload.Events {
+		if content := event.Message.Content(); content != expected[i] {
+			t.Errorf("Expected %#v, got %#v", expected[i], content)
+		}
+	}
+}
+
+func TestPostbackEvent(t *testing.T) {
+	payload, err := DeserializeWebhookMessage([]byte(`{"destination":1,"events":[
+		{"type":"follow","timestamp":1,"source":{"type":"user","userId":"u"}},
+		{"type":"postback","timestamp":2,"source":{"type":"user","userId":"u"},"postback":{"data":"action=apply"}}
+	]}`))
+	if err != nil {
+		t.Fatalf("Error deserializing payload: %v", err)
+	}
+
+	if payload.Events[0].Type != EventTypeFollow || payload.Events[0].Postback != nil {
+		t.Errorf("Unexpected follow event: %#v", payload.Events[0])
+	}
+
+	if payload.Events[1].Type != EventTypePostback || payload.Events[1].Postback == nil || payload.Events[1].Postback.Data != "action=apply" {
+		t.Errorf("Unexpected postback event: %#v", payload.Events[1])
+	}
+}
@@ -9,6 +9,34 @@ type Message struct {
 	Type string `json:"type,omitempty"`
 	Id   string `json:"id,omitempty"`
 	Text string `json:"text,omitempty"`
+
+	// Image message.
+	OriginalContentUrl string `json:"originalContentUrl,omitempty"`
+	PreviewImageUrl    string `json:"previewImageUrl,omitempty"`
+
+	// File message.
+	FileUrl  string `json:"fileUrl,omitempty"`
+	FileName string `json:"fileName,omitempty"`
+	FileSize int64  `json:"fileSize,omitempty"` // Inbound only.
+
+	// Sticker message.
+	PackageId string `json:"packageId,omitempty"`
+	StickerId string `json:"stickerId,omitempty"`
+
+	// Link message.
+	Url          string `json:"url,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Description  string `json:"description,omitempty"`
+	ThumbnailUrl string `json:"thumbnailUrl,omitempty"`
+
+	// Location message, inbound only. The title is shared with the link message.
+	Address   string  `json:"address,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+
+	// Template message.
+	AltText  string    `json:"altText,omitempty"`
+	Template *Template `json:"template,omitempty"`
 }
 
 // Received message source.
@@ -17,12 +45,31 @@ type MessageSource struct {
 	UserId string `json:"userId"`
 }
 
+// The type of a webhook event.
+type EventType string
+
+// Webhook event types.
+const (
+	EventTypeMessage  EventType = "message"  // A user sent a message.
+	EventTypeFollow   EventType = "follow"   // A user subscribed to the channel.
+	EventTypeUnfollow EventType = "unfollow" // A user unsubscribed from the channel.
+	EventTypeJoin     EventType = "join"     // The channel joined a group.
+	EventTypeLeave    EventType = "leave"    // The channel left a group.
+	EventTypePostback EventType = "postback" // A user tapped a postback action.
+)
+
+// The payload of a postback event.
+type Postback struct {
+	Data string `json:"data"`
+}
+
 // The message event from webhook.
 type MessageEvent struct {
-	Type      string        `json:"type"`
+	Type      EventType     `json:"type"`
 	Timestamp int           `json:"timestamp"`
 	Source    MessageSource `json:"source"`
 	Message   Message       `json:"message"`
+	Postback  *Postback     `json:"postback,omitempty"` // Set for postback events only.
 }
 
 // This is the received payload from the webhook.
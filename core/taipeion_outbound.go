@@ -0,0 +1,250 @@
+package taipeion_core
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"unicode/utf8"
+)
+
+// Message types.
+const (
+	MessageTypeText     = "text"
+	MessageTypeImage    = "image"
+	MessageTypeFile     = "file"
+	MessageTypeSticker  = "sticker"
+	MessageTypeLink     = "link"
+	MessageTypeTemplate = "template"
+)
+
+// Template types.
+const (
+	TemplateTypeButtons = "buttons" // A card with up to `MaxTemplateActions` buttons.
+	TemplateTypeConfirm = "confirm" // A question with exactly two buttons.
+)
+
+// Template action types.
+const (
+	ActionTypeUri      = "uri"      // Open a URL.
+	ActionTypePostback = "postback" // Send a postback event with data to the chatbot.
+	ActionTypeMessage  = "message"  // Send a text message as the user.
+)
+
+// Limits of outbound messages.
+const (
+	MaxTemplateActions    = 4
+	MaxActionLabelLength  = 20
+	MaxTemplateTextLength = 160
+	MaxAltTextLength      = 400
+	MaxPostbackDataLength = 300
+)
+
+var ErrInvalidMessage = errors.New("invalid message")
+
+// An action of a template message.
+type TemplateAction struct {
+	Type  string `json:"type"`
+	Label string `json:"label"`
+	Uri   string `json:"uri,omitempty"`  // Target of `uri` actions.
+	Data  string `json:"data,omitempty"` // Payload of `postback` actions.
+	Text  string `json:"text,omitempty"` // Text sent by `message` actions, or displayed by `postback` actions.
+}
+
+// The template of a template message.
+type Template struct {
+	Type              string           `json:"type"`
+	Title             string           `json:"title,omitempty"`
+	Text              string           `json:"text"`
+	ThumbnailImageUrl string           `json:"thumbnailImageUrl,omitempty"`
+	Actions           []TemplateAction `json:"actions"`
+}
+
+// # Text Message
+func NewTextMessage(text string) Message {
+	return Message{Type: MessageTypeText, Text: text}
+}
+
+// # Image Message
+//
+// The preview is displayed in the chat, the original is opened on tap.
+func NewImageMessage(originalContentUrl string, previewImageUrl string) Message {
+	return Message{Type: MessageTypeImage, OriginalContentUrl: originalContentUrl, PreviewImageUrl: previewImageUrl}
+}
+
+// # File Message
+func NewFileMessage(fileUrl string, fileName string) Message {
+	return Message{Type: MessageTypeFile, FileUrl: fileUrl, FileName: fileName}
+}
+
+// # Sticker Message
+func NewStickerMessage(packageId string, stickerId string) Message {
+	return Message{Type: MessageTypeSticker, PackageId: packageId, StickerId: stickerId}
+}
+
+// # Link Message
+//
+// A URL card with title, description and an optional thumbnail.
+func NewLinkMessage(linkUrl string, title string, description string, thumbnailUrl string) Message {
+	return Message{Type: MessageTypeLink, Url: linkUrl, Title: title, Description: description, ThumbnailUrl: thumbnailUrl}
+}
+
+// # Buttons Template Message
+//
+// The alternative text is displayed by clients unable to render templates.
+func NewButtonsTemplateMessage(altText string, title string, text string, actions ...TemplateAction) Message {
+	return Message{
+		Type:     MessageTypeTemplate,
+		AltText:  altText,
+		Template: &Template{Type: TemplateTypeButtons, Title: title, Text: text, Actions: actions},
+	}
+}
+
+// # Confirm Template Message
+func NewConfirmTemplateMessage(altText string, text string, confirm TemplateAction, cancel TemplateAction) Message {
+	return Message{
+		Type:     MessageTypeTemplate,
+		AltText:  altText,
+		Template: &Template{Type: TemplateTypeConfirm, Text: text, Actions: []TemplateAction{confirm, cancel}},
+	}
+}
+
+// # URI Action
+func NewUriAction(label string, uri string) TemplateAction {
+	return TemplateAction{Type: ActionTypeUri, Label: label, Uri: uri}
+}
+
+// # Postback Action
+//
+// The display text is shown in the chat as if the user sent it, leave empty to show nothing.
+func NewPostbackAction(label string, data string, displayText string) TemplateAction {
+	return TemplateAction{Type: ActionTypePostback, Label: label, Data: data, Text: displayText}
+}
+
+// # Message Action
+func NewMessageAction(label string, text string) TemplateAction {
+	return TemplateAction{Type: ActionTypeMessage, Label: label, Text: text}
+}
+
+func invalidMessage(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidMessage, fmt.Sprintf(format, args...))
+}
+
+// Check that a URL is absolute and uses HTTPS.
+func validateHttpsUrl(field string, raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return invalidMessage("%s must be an absolute HTTPS URL: %q", field, raw)
+	}
+	return nil
+}
+
+// # Validate Outbound Message
+//
+// Check that the message carries the fields required by its type.
+func (m Message) Validate() error {
+	switch m.Type {
+	case MessageTypeText:
+		if m.Text == "" {
+			return invalidMessage("text is empty")
+		}
+
+	case MessageTypeImage:
+		if err := validateHttpsUrl("original content URL", m.OriginalContentUrl); err != nil {
+			return err
+		}
+		return validateHttpsUrl("preview image URL", m.PreviewImageUrl)
+
+	case MessageTypeFile:
+		if m.FileName == "" {
+			return invalidMessage("file name is empty")
+		}
+		return validateHttpsUrl("file URL", m.FileUrl)
+
+	case MessageTypeSticker:
+		if m.PackageId == "" || m.StickerId == "" {
+			return invalidMessage("sticker requires package ID and sticker ID")
+		}
+
+	case MessageTypeLink:
+		if m.Title == "" {
+			return invalidMessage("link title is empty")
+		}
+		if err := validateHttpsUrl("link URL", m.Url); err != nil {
+			return err
+		}
+		if m.ThumbnailUrl != "" {
+			return validateHttpsUrl("thumbnail URL", m.ThumbnailUrl)
+		}
+
+	case MessageTypeTemplate:
+		if m.AltText == "" || utf8.RuneCountInString(m.AltText) > MaxAltTextLength {
+			return invalidMessage("alternative text must be 1 to %d characters", MaxAltTextLength)
+		}
+		if m.Template == nil {
+			return invalidMessage("template is missing")
+		}
+		return m.Template.Validate()
+
+	default:
+		return invalidMessage("unknown message type: %q", m.Type)
+	}
+	return nil
+}
+
+// # Validate Template
+func (t Template) Validate() error {
+	if t.Text == "" || utf8.RuneCountInString(t.Text) > MaxTemplateTextLength {
+		return invalidMessage("template text must be 1 to %d characters", MaxTemplateTextLength)
+	}
+
+	switch t.Type {
+	case TemplateTypeButtons:
+		if len(t.Actions) == 0 || len(t.Actions) > MaxTemplateActions {
+			return invalidMessage("buttons template requires 1 to %d actions", MaxTemplateActions)
+		}
+		if t.ThumbnailImageUrl != "" {
+			if err := validateHttpsUrl("thumbnail image URL", t.ThumbnailImageUrl); err != nil {
+				return err
+			}
+		}
+	case TemplateTypeConfirm:
+		if len(t.Actions) != 2 {
+			return invalidMessage("confirm template requires exactly 2 actions")
+		}
+	default:
+		return invalidMessage("unknown template type: %q", t.Type)
+	}
+
+	for _, action := range t.Actions {
+		if err := action.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// # Validate Template Action
+func (a TemplateAction) Validate() error {
+	if a.Label == "" || utf8.RuneCountInString(a.Label) > MaxActionLabelLength {
+		return invalidMessage("action label must be 1 to %d characters", MaxActionLabelLength)
+	}
+
+	switch a.Type {
+	case ActionTypeUri:
+		parsed, err := url.Parse(a.Uri)
+		if err != nil || parsed.Scheme == "" {
+			return invalidMessage("action URI must be absolute: %q", a.Uri)
+		}
+	case ActionTypePostback:
+		if a.Data == "" || len(a.Data) > MaxPostbackDataLength {
+			return invalidMessage("postback data must be 1 to %d bytes", MaxPostbackDataLength)
+		}
+	case ActionTypeMessage:
+		if a.Text == "" {
+			return invalidMessage("message action text is empty")
+		}
+	default:
+		return invalidMessage("unknown action type: %q", a.Type)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package taipeion_core
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestOutboundMessageRoundTrip(t *testing.T) {
+	messages := []Message{
+		NewTextMessage("Hello, World!"),
+		NewImageMessage("https://example.com/map.png", "https://example.com/map-preview.png"),
+		NewFileMessage("https://example.com/form.pdf", "form.pdf"),
+		NewStickerMessage("1", "2"),
+		NewLinkMessage("https://example.com", "Example", "An example page.", "https://example.com/thumb.png"),
+		NewButtonsTemplateMessage("Choose a service", "Services", "What do you need?",
+			NewUriAction("Website", "https://example.com"),
+			NewPostbackAction("Apply", "action=apply", "I want to apply"),
+			NewMessageAction("Help", "help"),
+		),
+		NewConfirmTemplateMessage("Confirm", "Are you sure?",
+			NewPostbackAction("Yes", "confirm=yes", ""),
+			NewPostbackAction("No", "confirm=no", ""),
+		),
+	}
+
+	for _, message := range messages {
+		if err := message.Validate(); err != nil {
+			t.Errorf("%s: unexpected validation error: %v", message.Type, err)
+		}
+
+		payload := ChannelMessagePayload{Ask: "sendMessage", Recipient: "user", Message: message}
+		data, err := payload.Serialize()
+		if err != nil {
+			t.Errorf("%s: error serializing message: %v", message.Type, err)
+			continue
+		}
+
+		var decoded ChannelMessagePayload
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Errorf("%s: error deserializing message: %v", message.Type, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(decoded, payload) {
+			t.Errorf("%s: round trip mismatch\nexpected %#v\ngot      %#v", message.Type, payload, decoded)
+		}
+	}
+}
+
+func TestImageMessageSerialize(t *testing.T) {
+	excepted := `{"type":"image","originalContentUrl":"https://example.com/a.png","previewImageUrl":"https://example.com/b.png"}`
+
+	data, err := json.Marshal(NewImageMessage("https://example.com/a.png", "https://example.com/b.png"))
+	if err != nil {
+		t.Errorf("Error serializing message: %v", err)
+	}
+
+	if string(data) != excepted {
+		t.Errorf("Expected %s, got %s", excepted, string(data))
+	}
+}
+
+func TestOutboundMessageValidation(t *testing.T) {
+	too_many := make([]TemplateAction, MaxTemplateActions+1)
+	for i := range too_many {
+		too_many[i] = NewMessageAction("Help", "help")
+	}
+
+	invalid := map[string]Message{
+		"empty text":         NewTextMessage(""),
+		"plain HTTP image":   NewImageMessage("http://example.com/a.png", "https://example.com/b.png"),
+		"file without name":  NewFileMessage("https://example.com/form.pdf", ""),
+		"incomplete sticker": NewStickerMessage("1", ""),
+		"relative link":      NewLinkMessage("/page", "Page", "", ""),
+		"too many actions":   NewButtonsTemplateMessage("alt", "", "text", too_many...),
+		"confirm with one":   {Type: MessageTypeTemplate, AltText: "alt", Template: &Template{Type: TemplateTypeConfirm, Text: "text", Actions: too_many[:1]}},
+		"long label":         NewButtonsTemplateMessage("alt", "", "text", NewMessageAction("A label way too long for a button", "help")),
+		"empty postback":     NewButtonsTemplateMessage("alt", "", "text", NewPostbackAction("Go", "", "")),
+		"missing alt text":   NewButtonsTemplateMessage("", "", "text", NewMessageAction("Help", "help")),
+		"unknown type":       {Type: "video"},
+	}
+
+	for name, message := range invalid {
+		if err := message.Validate(); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s: expected ErrInvalidMessage, got %v", name, err)
+		}
+	}
+}
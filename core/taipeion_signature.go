@@ -0,0 +1,47 @@
+package taipeion_core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// The default header carrying the webhook signature.
+const DefaultWebhookSignatureHeader = "X-TaipeiON-Signature"
+
+// # Sign Webhook Body
+//
+// Compute the signature of a raw webhook body with the channel secret.
+// The signature is the base64 encoded HMAC-SHA256 of the body.
+func SignWebhookBody(channelSecret string, body []byte) string {
+	return base64.StdEncoding.EncodeToString(webhookBodyMac(channelSecret, body))
+}
+
+// # Verify Webhook Signature
+//
+// Check the signature sent along with a webhook against the raw body.
+// Both base64 and hex encoded signatures are accepted.
+func VerifyWebhookSignature(channelSecret string, body []byte, signature string) bool {
+	signature = strings.TrimSpace(signature)
+	if channelSecret == "" || signature == "" {
+		return false
+	}
+
+	expected := webhookBodyMac(channelSecret, body)
+
+	if decoded, err := base64.StdEncoding.DecodeString(signature); err == nil && hmac.Equal(decoded, expected) {
+		return true
+	}
+	if decoded, err := hex.DecodeString(signature); err == nil && hmac.Equal(decoded, expected) {
+		return true
+	}
+	return false
+}
+
+func webhookBodyMac(channelSecret string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(channelSecret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,34 @@
+package taipeion_core
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	secret := "channel-secret"
+	body := []byte(`{"destination":1,"events":[]}`)
+
+	signature := SignWebhookBody(secret, body)
+	if !VerifyWebhookSignature(secret, body, signature) {
+		t.Errorf("Expected base64 signature %s to be valid", signature)
+	}
+
+	raw, _ := base64.StdEncoding.DecodeString(signature)
+	if !VerifyWebhookSignature(secret, body, hex.EncodeToString(raw)) {
+		t.Errorf("Expected hex signature to be valid")
+	}
+
+	if VerifyWebhookSignature("other-secret", body, signature) {
+		t.Errorf("Expected signature with wrong secret to be rejected")
+	}
+
+	if VerifyWebhookSignature(secret, []byte(`{"destination":2,"events":[]}`), signature) {
+		t.Errorf("Expected signature of tampered body to be rejected")
+	}
+
+	if VerifyWebhookSignature(secret, body, "") {
+		t.Errorf("Expected empty signature to be rejected")
+	}
+}
@@ -0,0 +1,120 @@
+package taipeion_core
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Default maximum length of a text message, in characters.
+const MaxTextLength = 2000
+
+// Boundaries to split text at, the preferred ones first.
+var splitBoundaries = []func(text []rune, i int) bool{
+	// Paragraph.
+	func(text []rune, i int) bool { return i >= 2 && text[i-1] == '\n' && text[i-2] == '\n' },
+	// Line.
+	func(text []rune, i int) bool { return text[i-1] == '\n' },
+	// Sentence.
+	func(text []rune, i int) bool { return endsSentence(text, i) },
+	// Clause.
+	func(text []rune, i int) bool {
+		return strings.ContainsRune("，、；：", text[i-1]) ||
+			strings.ContainsRune(",;:", text[i-1]) && i < len(text) && unicode.IsSpace(text[i])
+	},
+	// Word, or any character of scripts written without spaces.
+	func(text []rune, i int) bool {
+		return unicode.IsSpace(text[i-1]) || i < len(text) && unicode.IsSpace(text[i]) || isCjk(text[i-1]) && isCjk(text[i])
+	},
+}
+
+// Whether the text before `i` ends a sentence. Full-width punctuation ends a sentence as is,
+// ASCII punctuation only if followed by a space, so that numbers and URLs are kept whole.
+// Closing quotes and brackets after the punctuation belong to the sentence.
+func endsSentence(text []rune, i int) bool {
+	for i > 1 && strings.ContainsRune("」』）)]\"'”’", text[i-1]) {
+		i--
+	}
+	if strings.ContainsRune("。！？…", text[i-1]) {
+		return true
+	}
+	return strings.ContainsRune(".!?", text[i-1]) && i < len(text) && unicode.IsSpace(text[i])
+}
+
+// Whether the rune belongs to a script written without spaces.
+func isCjk(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Bopomofo)
+}
+
+// Find where to cut the text longer than `maxLength`, at the preferred boundary in the second
+// half of the allowed length.
+func findCut(text []rune, maxLength int) int {
+	for _, boundary := range splitBoundaries {
+		for i := maxLength; i > maxLength/2; i-- {
+			if boundary(text, i) {
+				return i
+			}
+		}
+	}
+
+	// No boundary, cut anywhere but before a combining mark.
+	cut := maxLength
+	for cut > 1 && unicode.Is(unicode.Mn, text[cut]) {
+		cut--
+	}
+	return cut
+}
+
+// # Split Text
+//
+// Split the text into parts of at most `maxLength` characters, counting runes, so that multi-byte
+// characters are never cut. The text is cut at the end of a paragraph, a line, a sentence, a clause
+// or a word, the first of them found in the second half of the allowed length; CJK text is cut
+// between any two characters. The whitespace around the cuts is removed.
+func SplitText(text string, maxLength int) []string {
+	remaining := []rune(strings.TrimSpace(text))
+	if maxLength <= 0 || len(remaining) <= maxLength {
+		if len(remaining) == 0 {
+			return nil
+		}
+		return []string{string(remaining)}
+	}
+
+	var parts []string
+	for len(remaining) > maxLength {
+		cut := findCut(remaining, maxLength)
+		if part := strings.TrimRightFunc(string(remaining[:cut]), unicode.IsSpace); part != "" {
+			parts = append(parts, part)
+		}
+		remaining = []rune(strings.TrimLeftFunc(string(remaining[cut:]), unicode.IsSpace))
+	}
+	if len(remaining) > 0 {
+		parts = append(parts, string(remaining))
+	}
+	return parts
+}
+
+// # Split Text with Numbering
+//
+// Same as `SplitText`, with " (1/3)" appended to each part when there are several of them.
+// The numbering counts against the maximum length.
+func SplitTextNumbered(text string, maxLength int) []string {
+	parts := SplitText(text, maxLength)
+	suffix_length := func(n int) int { return len(fmt.Sprintf(" (%d/%d)", n, n)) }
+
+	// Make room for the numbering, until the count of parts needs no more digits.
+	for n := len(parts); n > 1 && maxLength > suffix_length(n); n = len(parts) {
+		parts = SplitText(text, maxLength-suffix_length(n))
+		if suffix_length(len(parts)) <= suffix_length(n) {
+			break
+		}
+	}
+	if len(parts) <= 1 || maxLength <= suffix_length(len(parts)) {
+		return parts
+	}
+
+	for i := range parts {
+		parts[i] += fmt.Sprintf(" (%d/%d)", i+1, len(parts))
+	}
+	return parts
+}
@@ -0,0 +1,60 @@
+package taipeion_core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitText(t *testing.T) {
+	cases := []struct {
+		name      string
+		text      string
+		maxLength int
+		expected  []string
+	}{
+		{"short", "  Hello!  ", 10, []string{"Hello!"}},
+		{"empty", " \n ", 10, nil},
+		{"paragraph", "First line.\nSecond.\n\nNext paragraph.", 30, []string{"First line.\nSecond.", "Next paragraph."}},
+		{"sentence", "Pi is 3.14 exactly. It is not.", 25, []string{"Pi is 3.14 exactly.", "It is not."}},
+		{"word", "alpha beta gamma delta", 12, []string{"alpha beta", "gamma delta"}},
+		{"cjk sentence", "今天天氣很好。我們去公園散步吧！", 10, []string{"今天天氣很好。", "我們去公園散步吧！"}},
+		{"cjk quote", "他說：「好。」然後離開了。", 8, []string{"他說：「好。」", "然後離開了。"}},
+		{"cjk clause", "臺北市政府，市民服務熱線", 8, []string{"臺北市政府，", "市民服務熱線"}},
+		{"cjk anywhere", "一二三四五六七八九十", 4, []string{"一二三四", "五六七八", "九十"}},
+		{"hard cut", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+	}
+
+	for _, c := range cases {
+		parts := SplitText(c.text, c.maxLength)
+		if !reflect.DeepEqual(parts, c.expected) {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, parts)
+		}
+		for _, part := range parts {
+			if !utf8.ValidString(part) || utf8.RuneCountInString(part) > c.maxLength {
+				t.Errorf("%s: invalid part %q", c.name, part)
+			}
+		}
+	}
+}
+
+func TestSplitTextNumbered(t *testing.T) {
+	text := strings.Repeat("這是一個很長的回答。", 30) // 300 characters.
+	parts := SplitTextNumbered(text, 100)
+	if len(parts) != 4 {
+		t.Fatalf("Expected 4 parts, got %d: %q", len(parts), parts)
+	}
+	for i, part := range parts {
+		if utf8.RuneCountInString(part) > 100 {
+			t.Errorf("Part %d is too long: %d characters", i+1, utf8.RuneCountInString(part))
+		}
+	}
+	if !strings.HasSuffix(parts[0], "。 (1/4)") || !strings.HasSuffix(parts[3], " (4/4)") {
+		t.Errorf("Expected the parts to be numbered at sentence ends, got %q", parts)
+	}
+
+	if parts := SplitTextNumbered("short", 100); !reflect.DeepEqual(parts, []string{"short"}) {
+		t.Errorf("Expected a single part without numbering, got %q", parts)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"log"
 	"os"
 
+	tp "taipeion/core"
+
 	"gopkg.in/yaml.v3"
 )
 
@@ -43,6 +45,7 @@ func main() {
 	// Define command-line flags
 	configPath := flag.String("config", "config.yaml", "Path to the config file")
 	llmDebug := flag.Bool("llm-local-debug", false, "Enable local debug mode for LLM, preventing requests to the LLM endpoint")
+	adminUrl := flag.String("admin-url", "", "URL of the admin endpoints used by subcommands, defaults to the local chatbot")
 
 	// Parse command-line flags
 	flag.Parse()
@@ -55,18 +58,42 @@ func main() {
 	// Load the configuration
 	config := loadConfig(*configPath)
 
+	// Run the subcommand if any.
+	if flag.Arg(0) == "dead-letters" {
+		if err := runDeadLetterCommand(config, *adminUrl, flag.Args()[1:]); err != nil {
+			log.Fatalf("[DeadLetter] Error: %v", err)
+		}
+		return
+	}
+	if flag.Arg(0) == "outbox" {
+		if err := runOutboxCommand(config, *adminUrl, flag.Args()[1:]); err != nil {
+			log.Fatalf("[Outbox] Error: %v", err)
+		}
+		return
+	}
+
 	// Create a new chatbot instance
-	bot := NewChatbotFromConfig(config)
+	bot, err := NewChatbotFromConfig(config)
+	if err != nil {
+		log.Fatalf("[Init] Error: Invalid configuration: %v", err)
+	}
 
 	llm := NewLlmConnector(config.Channels, *llmDebug)
 
+	// Register global middlewares.
+	bot.Use(RecoverMiddleware(), LoggingMiddleware(), MetricsMiddleware(), BlocklistMiddleware(config.BlockedUsers...))
+
 	// Register callbacks.
-	bot.RegisterWebhookEventCallback(
-		ScheduleCallbackNormalPriority(llm.LlmCallback),
+	bot.Route(
+		ScheduleContextCallbackNormalPriority(llm.LlmCallback).Named("llm").WithWaitNotifier(llm.WaitNotifier).WithRateLimit(),
+		llm.Matcher(),
 	)
 
-	bot.RegisterWebhookEventCallback(
+	bot.OnFollow(WelcomeMessageCallback)
+
+	bot.Route(
 		ScheduleCallbackHighestPriority(SimpleWebhookEventCallback),
+		MatchMessageTypes(tp.MessageTypeText),
 	)
 	// Start the chatbot
 	if err := bot.Start(); err != nil {
@@ -8,13 +8,26 @@ import (
 /*
 # Guide: How to design a chatbot callback
 
-- Filter the incoming event to make sure it is what you want to process:
+- Describe the events you want to process with matchers upon registration,
+  the callback is only called on matching events:
 
 ```go
-// Check if incoming event is text message.
-if event.Message.Type != "text" {
-	log.Println("Received non-text message. Ignoring.")
-	return nil
+bot.Route(
+	ScheduleCallbackNormalPriority(YourCallbackFunction),
+	MatchMessageTypes(tp.MessageTypeText),
+	MatchChannels(1, 2),
+	MatchPrefix("/help"),
+)
+```
+
+- Non-text messages carry typed content:
+
+```go
+switch content := event.Content().(type) {
+case tp.FileContent:
+	log.Println(content.FileName, content.FileSize)
+case tp.LocationContent:
+	log.Println(content.Latitude, content.Longitude)
 }
 ```
 
@@ -30,10 +43,34 @@ Broadcast message:
 err := bot.SendBroadcastMessage(reply_message)
 ```
 
+Rich messages (image, file, sticker, link card, template):
+```go
+err := bot.SendPrivateImage(receiver, original_url, preview_url, chan_id)
+err := bot.SendPrivate(receiver, tp.NewConfirmTemplateMessage(alt_text, question, yes_action, no_action), chan_id)
+```
+
 - Register the callback (in the main function):
 
 ```go
-bot.RegisterWebhookEventCallback(YourCallbackFunction)
+bot.RegisterWebhookEventCallback(ScheduleCallbackNormalPriority(YourCallbackFunction))
+```
+
+Callbacks taking a context are cancelled on deadline or shutdown, and carry the request ID and a logger:
+
+```go
+func YourCallbackFunction(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent) error {
+	LoggerFromContext(ctx).Println("Handling event.")
+	return bot.SendPrivateMessageContext(ctx, receiver, reply_message, chan_id)
+}
+
+bot.RegisterWebhookEventCallback(ScheduleContextCallbackNormalPriority(YourCallbackFunction).WithTimeout(time.Minute))
+```
+
+Or for a single event type:
+
+```go
+bot.OnFollow(YourWelcomeCallback)
+bot.OnPostback(YourPostbackCallback) // The data is in `event.Postback.Data`.
 ```
 
 Followings are some examples of chatbot callbacks.
@@ -42,13 +79,8 @@ Followings are some examples of chatbot callbacks.
 // # Simple webhook event callback.
 //
 // This callback puts the incoming message to the log (stdout).
+// Register it with `MatchMessageTypes(tp.MessageTypeText)`.
 func SimpleWebhookEventCallback(bot *TaipeionBot, event ChatbotWebhookEvent) error {
-	// Check if incoming event is text message.
-	if event.Message.Type != "text" {
-		log.Println("[SimpleStdCallback] Received non-text message. Ignoring.")
-		return nil
-	}
-
 	log.Printf("[SimpleStdCallback] Received event: %#v\n", event)
 	return nil
 }
@@ -56,13 +88,8 @@ func SimpleWebhookEventCallback(bot *TaipeionBot, event ChatbotWebhookEvent) err
 // # Private message callback.
 //
 // This callback is used to send a reply to a cer
+// Register it with `MatchMessageTypes(tp.MessageTypeText)`.
 func PrivateMessageCallback(bot *TaipeionBot, event ChatbotWebhookEvent) error {
-	// Check if incoming event is text message.
-	if event.Message.Type != "text" {
-		log.Println("[PrivateMessageCallback] Received non-text message. Ignoring.")
-		return nil
-	}
-
 	chan_id := event.Destination
 	reply_message := event.Message.Text
 	receiver := event.Source.UserId
@@ -73,3 +100,17 @@ func PrivateMessageCallback(bot *TaipeionBot, event ChatbotWebhookEvent) error {
 	return bot.SendPrivateMessage(receiver, reply_message, chan_id)
 
 }
+
+// # Welcome message callback.
+//
+// This callback greets new subscribers with the welcome message of the channel, if configured.
+// Register it with `bot.OnFollow`.
+func WelcomeMessageCallback(bot *TaipeionBot, event ChatbotWebhookEvent) error {
+	channel, ok := bot.Channels[event.Destination]
+	if !ok || channel.WelcomeMessage == "" {
+		return nil
+	}
+
+	log.Printf("[WelcomeCallback] New subscriber (%s) on channel (%d).\n", event.Source.UserId, event.Destination)
+	return bot.SendPrivateMessage(event.Source.UserId, channel.WelcomeMessage, event.Destination)
+}
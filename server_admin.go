@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// # Admin Routes
+//
+// Register the operator endpoints under the admin path, every request must carry
+// the admin token as a bearer token:
+//
+//	GET    <admin-path>/dead-letters               List the dead letters.
+//	GET    <admin-path>/dead-letters/{id}          Inspect a dead letter.
+//	POST   <admin-path>/dead-letters/{id}/replay   Replay a dead letter.
+//	DELETE <admin-path>/dead-letters/{id}          Discard a dead letter.
+//	GET    <admin-path>/outbox?status=<status>     List the outbound messages, of a status if given.
+//	GET    <admin-path>/outbox/{id}                Inspect the delivery status of an outbound message.
+func (tpb *TaipeionBot) registerAdminRoutes(mux *http.ServeMux) {
+	if tpb.adminPath == "" {
+		return
+	}
+	if tpb.adminToken == "" {
+		log.Println("[EvListener] Error: Admin token is not set, admin endpoints are disabled.")
+		return
+	}
+
+	prefix := strings.TrimSuffix(tpb.adminPath, "/")
+	log.Printf("[EvListener] Registering admin routes: %s\n", prefix)
+
+	mux.HandleFunc("GET "+prefix+"/dead-letters", tpb.adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, tpb.DeadLetters())
+	}))
+
+	mux.HandleFunc("GET "+prefix+"/dead-letters/{id}", tpb.adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		if tpb.deadLetters == nil {
+			http.NotFound(w, r)
+			return
+		}
+		letter, ok := tpb.deadLetters.get(r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJson(w, http.StatusOK, letter)
+	}))
+
+	mux.HandleFunc("POST "+prefix+"/dead-letters/{id}/replay", tpb.adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		writeAdminResult(w, tpb.ReplayDeadLetter(r.PathValue("id")), http.StatusAccepted)
+	}))
+
+	mux.HandleFunc("DELETE "+prefix+"/dead-letters/{id}", tpb.adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		writeAdminResult(w, tpb.DeleteDeadLetter(r.PathValue("id")), http.StatusOK)
+	}))
+
+	mux.HandleFunc("GET "+prefix+"/outbox", tpb.adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, tpb.OutboxMessages(r.URL.Query().Get("status")))
+	}))
+
+	mux.HandleFunc("GET "+prefix+"/outbox/{id}", tpb.adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		message, err := tpb.OutboxMessage(r.PathValue("id"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJson(w, http.StatusOK, message)
+	}))
+}
+
+// Reject requests without the admin token.
+func (tpb *TaipeionBot) adminAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(tpb.adminToken)) != 1 {
+			log.Printf("[EvListener] Warning: Unauthorized admin request %s %s from %s.\n", r.Method, r.URL.Path, r.RemoteAddr)
+			tpb.Metrics.Inc("admin_unauthorized_total")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Write the result of an admin action.
+func writeAdminResult(w http.ResponseWriter, err error, success int) {
+	switch {
+	case err == nil:
+		writeJson(w, success, response{Status: "ok"})
+	case errors.Is(err, ErrDeadLetterNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrDeadLetterReplaying):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	}
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
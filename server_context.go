@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// # Context-aware Webhook Event Callback
+//
+// The context carries the deadline of the handler, the request ID and a logger, see `RequestIdFromContext`
+// and `LoggerFromContext`. It is cancelled when the deadline is exceeded or the chatbot shuts down.
+type ContextEventCallback func(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent) error
+
+type contextKey int
+
+const (
+	requestIdKey contextKey = iota
+	loggerKey
+	handlerNameKey
+)
+
+// # Adapt Callback
+//
+// Turn a callback without context into a context-aware callback, the context is ignored.
+func AdaptCallback(callback WebhookEventCallback) ContextEventCallback {
+	return func(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent) error {
+		return callback(bot, event)
+	}
+}
+
+// Generate a random request ID.
+func newRequestId() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// # Handler Context
+//
+// Attach the request ID, the handler name and a logger prefixed with both to the context.
+func withHandlerContext(ctx context.Context, requestId string, handlerName string) context.Context {
+	logger := log.New(log.Writer(), fmt.Sprintf("[req:%s] [%s] ", requestId, handlerName), log.Flags()|log.Lmsgprefix)
+
+	ctx = context.WithValue(ctx, requestIdKey, requestId)
+	ctx = context.WithValue(ctx, handlerNameKey, handlerName)
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// # Request ID from Context
+//
+// Get the ID of the webhook request the event was received with, empty if not set.
+func RequestIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+// # Handler Name from Context
+func HandlerNameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(handlerNameKey).(string)
+	return name
+}
+
+// # Logger from Context
+//
+// Get the logger of the handler, falls back to the standard logger.
+func LoggerFromContext(ctx context.Context) *log.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*log.Logger); ok {
+		return logger
+	}
+	return log.Default()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	api_platform "github.com/h-alice/tcg-api-platform-client"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultCredentialRefreshBefore = 5 * time.Minute
+	defaultCredentialLifetime      = 24 * time.Hour // The API platform tokens expire after a day.
+	defaultCredentialFetchTimeout  = 30 * time.Second
+	defaultCredentialMinRefresh    = time.Minute // Minimum age of credentials dropped after a rejection.
+)
+
+// # Credential Configuration
+//
+// Caching of the API platform access token and sign block.
+type CredentialConfig struct {
+	RefreshBefore time.Duration `yaml:"refresh-before"` // Refresh the credentials this long before they expire.
+	Lifetime      time.Duration `yaml:"lifetime"`       // Lifetime of the credentials, the API platform does not tell.
+}
+
+// Fill zero values of the credential configuration with defaults.
+func newCredentialConfig(config CredentialConfig) CredentialConfig {
+	if config.RefreshBefore <= 0 {
+		config.RefreshBefore = defaultCredentialRefreshBefore
+	}
+	if config.Lifetime <= 0 {
+		config.Lifetime = defaultCredentialLifetime
+	}
+	return config
+}
+
+// The access token of the API platform, and the client holding the sign block.
+type apiCredentials struct {
+	AccessToken string
+	ExpiresAt   time.Time
+	FetchedAt   time.Time
+	client      *api_platform.ApiPlatformClient // Signs the payloads with the sign block.
+}
+
+// Request new credentials from the API platform.
+type credentialFetcher func(ctx context.Context) (apiCredentials, error)
+
+// # Credential Manager
+//
+// Cache the API platform credentials and refresh them before they expire.
+// Concurrent refreshes share a single request.
+type credentialManager struct {
+	mu         sync.Mutex
+	config     CredentialConfig
+	current    apiCredentials
+	fetch      credentialFetcher
+	group      singleflight.Group
+	refreshing bool // A background refresh is in flight.
+	metrics    *MetricsRegistry
+	now        func() time.Time
+}
+
+func newCredentialManager(config CredentialConfig, fetch credentialFetcher, metrics *MetricsRegistry) *credentialManager {
+	return &credentialManager{
+		config:  newCredentialConfig(config),
+		fetch:   fetch,
+		metrics: metrics,
+		now:     time.Now,
+	}
+}
+
+// # Get Credentials
+//
+// Return the cached credentials, refreshing them if they expired.
+// Credentials about to expire are returned as is and refreshed in the background.
+func (m *credentialManager) get(ctx context.Context) (apiCredentials, error) {
+	m.mu.Lock()
+	current := m.current
+	now := m.now()
+	valid := current.AccessToken != "" && now.Before(current.ExpiresAt)
+	if valid && !now.Before(current.ExpiresAt.Add(-m.config.RefreshBefore)) && !m.refreshing {
+		m.refreshing = true
+		go func() {
+			if _, err := m.refresh(context.Background()); err != nil {
+				log.Printf("[ReqSender] Error: Unable to refresh the credentials ahead of expiry: %s\n", err)
+			}
+			m.mu.Lock()
+			m.refreshing = false
+			m.mu.Unlock()
+		}()
+	}
+	m.mu.Unlock()
+
+	if valid {
+		return current, nil
+	}
+	return m.refresh(ctx)
+}
+
+// # Invalidate Credentials
+//
+// Drop the credentials rejected by the API platform, unless they were refreshed meanwhile.
+// A rejection may also be caused by the channel access token, so credentials fetched less than
+// `defaultCredentialMinRefresh` ago are kept, and a wrong channel token cannot trigger a refresh per request.
+// Returns whether the current credentials differ from the rejected ones.
+func (m *credentialManager) invalidate(rejected apiCredentials) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.current.AccessToken != rejected.AccessToken {
+		return true
+	}
+	if m.now().Sub(m.current.FetchedAt) < defaultCredentialMinRefresh {
+		return false
+	}
+	m.current = apiCredentials{}
+	return true
+}
+
+// Request new credentials, callers arriving while a request is in flight share its result.
+// The request itself is not bound to the context of a single caller.
+func (m *credentialManager) refresh(ctx context.Context) (apiCredentials, error) {
+	result := m.group.DoChan("credentials", func() (any, error) {
+		fetch_ctx, cancel := context.WithTimeout(context.Background(), defaultCredentialFetchTimeout)
+		defer cancel()
+
+		m.metrics.Inc("credential_refreshes_total")
+		credentials, err := m.fetch(fetch_ctx)
+		if err != nil {
+			m.metrics.Inc("credential_refresh_failures_total")
+			return apiCredentials{}, err
+		}
+		credentials.FetchedAt = m.now()
+		if credentials.ExpiresAt.IsZero() {
+			credentials.ExpiresAt = credentials.FetchedAt.Add(m.config.Lifetime)
+		}
+
+		m.mu.Lock()
+		m.current = credentials
+		m.mu.Unlock()
+		return credentials, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return apiCredentials{}, ctx.Err()
+	case r := <-result:
+		if r.Err != nil {
+			return apiCredentials{}, r.Err
+		}
+		return r.Val.(apiCredentials), nil
+	}
+}
+
+// # API Platform Credential Fetcher
+//
+// Request the access token, then the sign block, with a new API platform client.
+// The client requests cannot be bound to the context, once it is done they are left to finish in the background.
+func apiPlatformCredentialFetcher(endpoint string, clientId string, clientToken string) credentialFetcher {
+	type result struct {
+		credentials apiCredentials
+		err         error
+	}
+
+	return func(ctx context.Context) (apiCredentials, error) {
+		done := make(chan result, 1)
+		go func() {
+			client := api_platform.NewApiPlatformClient(endpoint, clientId, clientToken)
+
+			access_token, err := client.RequestAccessToken()
+			if err != nil {
+				done <- result{err: fmt.Errorf("unable to request access token: %w", err)}
+				return
+			}
+			if _, err := client.RequestSignBlock(); err != nil {
+				done <- result{err: fmt.Errorf("unable to request sign block: %w", err)}
+				return
+			}
+			done <- result{credentials: apiCredentials{AccessToken: access_token, client: client}}
+		}()
+
+		select {
+		case <-ctx.Done():
+			return apiCredentials{}, ctx.Err()
+		case r := <-done:
+			return r.credentials, r.err
+		}
+	}
+}
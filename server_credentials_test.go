@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tp "taipeion/core"
+
+	api_platform "github.com/h-alice/tcg-api-platform-client"
+)
+
+// Fetch numbered credentials, counting the requests.
+func testCredentialFetcher(fetches *atomic.Int64) credentialFetcher {
+	return func(ctx context.Context) (apiCredentials, error) {
+		n := fetches.Add(1)
+		return apiCredentials{AccessToken: fmt.Sprintf("token-%d", n), client: &api_platform.ApiPlatformClient{}}, nil
+	}
+}
+
+// A bot sending to a test endpoint, with channel 1 configured and credentials from `testCredentialFetcher`.
+func sendTestBot(t *testing.T, fetches *atomic.Int64, handler http.HandlerFunc) *TaipeionBot {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	bot := NewChatbotInstance(server.URL, map[int]Channel{1: {}}, "", 0, "", "", "", 1)
+	bot.credentials.fetch = testCredentialFetcher(fetches)
+	return bot
+}
+
+func TestCredentialManagerCachesAndRefreshes(t *testing.T) {
+	var fetches atomic.Int64
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) (apiCredentials, error) {
+		n := fetches.Add(1)
+		<-release
+		return apiCredentials{AccessToken: fmt.Sprintf("token-%d", n), client: &api_platform.ApiPlatformClient{}}, nil
+	}
+
+	now := time.Unix(0, 0)
+	var now_mu sync.Mutex
+	manager := newCredentialManager(CredentialConfig{RefreshBefore: time.Minute, Lifetime: time.Hour}, fetch, NewMetricsRegistry())
+	manager.now = func() time.Time {
+		now_mu.Lock()
+		defer now_mu.Unlock()
+		return now
+	}
+
+	// Concurrent callers share a single request.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if credentials, err := manager.get(context.Background()); err != nil || credentials.AccessToken != "token-1" {
+				t.Errorf("Expected the first token, got %v, %v", credentials, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if fetches.Load() != 1 {
+		t.Fatalf("Expected a single request, got %d", fetches.Load())
+	}
+
+	// Cached until shortly before expiry.
+	manager.get(context.Background())
+	if fetches.Load() != 1 {
+		t.Errorf("Expected the cached credentials, got %d requests", fetches.Load())
+	}
+
+	// Refreshed in the background while still valid.
+	now_mu.Lock()
+	now = now.Add(59*time.Minute + 30*time.Second)
+	now_mu.Unlock()
+	if credentials, _ := manager.get(context.Background()); credentials.AccessToken != "token-1" {
+		t.Errorf("Expected the credentials about to expire, got %s", credentials.AccessToken)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if credentials, _ := manager.get(context.Background()); credentials.AccessToken == "token-2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the credentials to be refreshed ahead of expiry")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Rejected credentials are dropped, unless they were refreshed meanwhile or are too fresh.
+	if !manager.invalidate(apiCredentials{AccessToken: "token-1"}) {
+		t.Errorf("Expected replaced credentials to be reported")
+	}
+	if manager.invalidate(apiCredentials{AccessToken: "token-2"}) {
+		t.Errorf("Expected fresh credentials to be kept")
+	}
+	if credentials, _ := manager.get(context.Background()); credentials.AccessToken != "token-2" {
+		t.Errorf("Expected the newer credentials to be kept, got %s", credentials.AccessToken)
+	}
+	now_mu.Lock()
+	now = now.Add(2 * time.Minute)
+	now_mu.Unlock()
+	manager.invalidate(apiCredentials{AccessToken: "token-2"})
+	if credentials, _ := manager.get(context.Background()); credentials.AccessToken != "token-3" {
+		t.Errorf("Expected new credentials, got %s", credentials.AccessToken)
+	}
+}
+
+func TestEndpointPostRequestRetriesOnAuthFailure(t *testing.T) {
+	var requests, fetches atomic.Int64
+	bot := sendTestBot(t, &fetches, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+
+	// Credentials fetched an hour ago, and rejected, are refreshed once.
+	bot.credentials.current = apiCredentials{
+		AccessToken: fmt.Sprintf("token-%d", fetches.Add(1)),
+		FetchedAt:   time.Now().Add(-time.Hour),
+		ExpiresAt:   time.Now().Add(time.Hour),
+		client:      &api_platform.ApiPlatformClient{},
+	}
+	if err := bot.SendPrivateMessage("citizen", "hello", 1); err != nil {
+		t.Fatalf("Expected the message to be sent, got %s", err)
+	}
+	if requests.Load() != 2 || fetches.Load() != 2 {
+		t.Errorf("Expected one retry with new credentials, got %d requests and %d refreshes", requests.Load(), fetches.Load())
+	}
+
+	// Credentials are cached across requests.
+	bot.SendPrivate("citizen", tp.NewTextMessage("hello"), 1)
+	if fetches.Load() != 2 {
+		t.Errorf("Expected the cached credentials, got %d refreshes", fetches.Load())
+	}
+}
+
+func TestEndpointPostRequestKeepsFreshCredentials(t *testing.T) {
+	var requests, fetches atomic.Int64
+	bot := sendTestBot(t, &fetches, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	// A rejection of fresh credentials points at the channel access token, nothing is refreshed.
+	for i := 0; i < 3; i++ {
+		bot.SendPrivateMessage("citizen", "hello", 1)
+	}
+	if requests.Load() != 3 || fetches.Load() != 1 {
+		t.Errorf("Expected no refresh, got %d requests and %d refreshes", requests.Load(), fetches.Load())
+	}
+}
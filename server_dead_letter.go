@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultDeadLetterMaxEntries = 1000
+
+var (
+	ErrDeadLetterNotFound  = errors.New("dead letter not found")
+	ErrDeadLetterReplaying = errors.New("dead letter is being replayed")
+	ErrHandlerNotFound     = errors.New("handler not registered")
+	ErrHandlerAmbiguous    = errors.New("several handlers share the name")
+)
+
+// # Dead-letter Configuration
+type DeadLetterConfig struct {
+	Disabled   bool   `yaml:"disabled"`    // Drop events whose handler failed after all attempts.
+	Path       string `yaml:"path"`        // Directory of the dead letters, kept in memory if empty.
+	MaxEntries int    `yaml:"max-entries"` // Maximum number of dead letters, the oldest ones are evicted.
+}
+
+// # Dead Letter
+//
+// An event whose handler failed after all attempts.
+type DeadLetter struct {
+	Id           string              `json:"id"`
+	Handler      string              `json:"handler"`       // Name of the failed handler.
+	HandlerIndex int                 `json:"handler_index"` // Registration index of the failed handler.
+	Event        ChatbotWebhookEvent `json:"event"`         // The event, replayed as is.
+	Error        string              `json:"error"`         // The last error.
+	Attempts     int                 `json:"attempts"`      // Attempts of the last execution.
+	Replays      int                 `json:"replays"`       // Failed replays.
+	FailedAt     time.Time           `json:"failed_at"`
+}
+
+// # Dead-letter Store
+//
+// Keep the dead letters in memory, and as one JSON file per letter if a directory is set,
+// so that they survive restarts.
+type deadLetterStore struct {
+	mu         sync.Mutex
+	dir        string
+	maxEntries int
+	letters    map[string]DeadLetter
+	replaying  map[string]struct{} // Letters being replayed.
+}
+
+// # Open Dead-letter Store
+//
+// Create the store from configuration and load the letters from the directory.
+// Returns nil if disabled.
+func openDeadLetterStore(config DeadLetterConfig) (*deadLetterStore, error) {
+	if config.Disabled {
+		return nil, nil
+	}
+
+	store := &deadLetterStore{
+		dir:        config.Path,
+		maxEntries: config.MaxEntries,
+		letters:    make(map[string]DeadLetter),
+		replaying:  make(map[string]struct{}),
+	}
+	if store.maxEntries <= 0 {
+		store.maxEntries = defaultDeadLetterMaxEntries
+	}
+	if store.dir == "" {
+		return store, nil
+	}
+
+	if err := os.MkdirAll(store.dir, 0o755); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(store.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var letter DeadLetter
+		if err := json.Unmarshal(content, &letter); err != nil {
+			log.Printf("[DeadLetter] Error: Skipping malformed dead letter %s: %s\n", file, err)
+			continue
+		}
+		store.letters[letter.Id] = letter
+	}
+	if len(store.letters) > 0 {
+		log.Printf("[DeadLetter] Loaded %d dead letters from %s.\n", len(store.letters), store.dir)
+	}
+	return store, nil
+}
+
+// Path of the file of a letter.
+func (s *deadLetterStore) letterPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// # Put Dead Letter
+//
+// Add or replace a letter, evicting the oldest one if the store is full.
+func (s *deadLetterStore) put(letter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.letters[letter.Id]; !ok && len(s.letters) >= s.maxEntries {
+		oldest := s.sortedLocked()[0]
+		log.Printf("[DeadLetter] Warning: Store is full, evicting dead letter (%s).\n", oldest.Id)
+		if err := s.removeLocked(oldest.Id); err != nil {
+			return err
+		}
+	}
+
+	if s.dir != "" {
+		content, err := json.Marshal(letter)
+		if err != nil {
+			return err
+		}
+		// Write to a temporary file first, so that a crash never leaves a truncated letter.
+		temp_path := s.letterPath(letter.Id) + ".tmp"
+		if err := os.WriteFile(temp_path, content, 0o644); err != nil {
+			return err
+		}
+		if err := os.Rename(temp_path, s.letterPath(letter.Id)); err != nil {
+			return err
+		}
+	}
+
+	s.letters[letter.Id] = letter
+	return nil
+}
+
+// # Get Dead Letter
+func (s *deadLetterStore) get(id string) (DeadLetter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	letter, ok := s.letters[id]
+	return letter, ok
+}
+
+// # List Dead Letters
+//
+// Get every letter, the oldest first.
+func (s *deadLetterStore) list() []DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedLocked()
+}
+
+func (s *deadLetterStore) sortedLocked() []DeadLetter {
+	letters := make([]DeadLetter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].FailedAt.Before(letters[j].FailedAt) })
+	return letters
+}
+
+// # Remove Dead Letter
+func (s *deadLetterStore) remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.letters[id]; !ok {
+		return ErrDeadLetterNotFound
+	}
+	return s.removeLocked(id)
+}
+
+func (s *deadLetterStore) removeLocked(id string) error {
+	if s.dir != "" {
+		if err := os.Remove(s.letterPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	delete(s.letters, id)
+	return nil
+}
+
+// Mark a letter as being replayed, returns false if it already is.
+func (s *deadLetterStore) startReplay(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.replaying[id]; ok {
+		return false
+	}
+	s.replaying[id] = struct{}{}
+	return true
+}
+
+func (s *deadLetterStore) finishReplay(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.replaying, id)
+}
+
+func (s *deadLetterStore) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.letters)
+}
+
+// # Park Dead Letter
+//
+// Store the event of a handler failed after all attempts.
+// A failed replay updates the existing letter.
+func (tpb *TaipeionBot) parkDeadLetter(entry eventHandlerEntry, event ChatbotWebhookEvent, err error, attempts int, letterId string) {
+	if tpb.deadLetters == nil {
+		return
+	}
+
+	letter := DeadLetter{
+		Id:           letterId,
+		Handler:      entry.Name,
+		HandlerIndex: entry.index,
+		Event:        event,
+		Error:        err.Error(),
+		Attempts:     attempts,
+		FailedAt:     time.Now(),
+	}
+	if previous, ok := tpb.deadLetters.get(letterId); ok {
+		letter.Replays = previous.Replays + 1
+	} else {
+		letter.Id = newRequestId()
+	}
+
+	if err := tpb.deadLetters.put(letter); err != nil {
+		log.Printf("[DeadLetter] Error: Unable to park event of handler (%s) on request (%s): %s\n", entry.Name, event.RequestId, err)
+		return
+	}
+	log.Printf("[DeadLetter] Parked event of handler (%s) on request (%s) as dead letter (%s).\n", entry.Name, event.RequestId, letter.Id)
+	tpb.Metrics.Inc("dead_letters_parked_total")
+	tpb.Metrics.Set("dead_letters", int64(tpb.deadLetters.size()))
+}
+
+// # Dead Letters
+//
+// List the dead letters, the oldest first.
+func (tpb *TaipeionBot) DeadLetters() []DeadLetter {
+	if tpb.deadLetters == nil {
+		return nil
+	}
+	return tpb.deadLetters.list()
+}
+
+// # Replay Dead Letter
+//
+// Call the failed handler again with the event of the letter, in the background.
+// The letter is removed once the handler succeeds, and updated if it fails again.
+func (tpb *TaipeionBot) ReplayDeadLetter(id string) error {
+	if tpb.deadLetters == nil {
+		return ErrDeadLetterNotFound
+	}
+	letter, ok := tpb.deadLetters.get(id)
+	if !ok {
+		return ErrDeadLetterNotFound
+	}
+
+	entry, err := tpb.deadLetterHandler(letter)
+	if err != nil {
+		return err
+	}
+
+	if !tpb.deadLetters.startReplay(id) {
+		return ErrDeadLetterReplaying
+	}
+
+	log.Printf("[DeadLetter] Replaying dead letter (%s) with handler (%s).\n", id, letter.Handler)
+	tpb.Metrics.Inc("dead_letters_replayed_total")
+
+	tpb.runningHandlers.Add(1)
+	go func() {
+		defer tpb.runningHandlers.Done()
+		defer tpb.deadLetters.finishReplay(id)
+
+		if err := tpb.executeEventHandler(tpb.handlersCtx, entry, letter.Event, id); err != nil {
+			return
+		}
+		if err := tpb.deadLetters.remove(id); err != nil {
+			log.Printf("[DeadLetter] Error: Unable to remove replayed dead letter (%s): %s\n", id, err)
+		}
+		log.Printf("[DeadLetter] Dead letter (%s) replayed successfully.\n", id)
+		tpb.Metrics.Set("dead_letters", int64(tpb.deadLetters.size()))
+	}()
+	return nil
+}
+
+// # Handler of a Dead Letter
+//
+// Find the failed handler by its registration index, the name must still match.
+// Handlers sharing the name are refused, since the registration order may differ after a restart.
+func (tpb *TaipeionBot) deadLetterHandler(letter DeadLetter) (eventHandlerEntry, error) {
+	named := 0
+	for _, entry := range tpb.eventHandlers {
+		if entry.Name == letter.Handler {
+			named++
+		}
+	}
+
+	switch {
+	case named > 1:
+		return eventHandlerEntry{}, fmt.Errorf("%w: %s", ErrHandlerAmbiguous, letter.Handler)
+	case named == 0 || letter.HandlerIndex < 0 || letter.HandlerIndex >= len(tpb.eventHandlers) || tpb.eventHandlers[letter.HandlerIndex].Name != letter.Handler:
+		return eventHandlerEntry{}, fmt.Errorf("%w: %s", ErrHandlerNotFound, letter.Handler)
+	}
+	return tpb.eventHandlers[letter.HandlerIndex], nil
+}
+
+// # Delete Dead Letter
+func (tpb *TaipeionBot) DeleteDeadLetter(id string) error {
+	if tpb.deadLetters == nil {
+		return ErrDeadLetterNotFound
+	}
+	if err := tpb.deadLetters.remove(id); err != nil {
+		return err
+	}
+	tpb.Metrics.Set("dead_letters", int64(tpb.deadLetters.size()))
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tp "taipeion/core"
+)
+
+func TestRetryThenDeadLetter(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	bot.retry = RetryConfig{MaxAttempts: 1, Backoff: time.Millisecond, BackoffMax: time.Millisecond}
+
+	calls := 0
+	failing := true
+	bot.RegisterWebhookEventCallback(ScheduleCallbackHighestPriority(func(bot *TaipeionBot, event ChatbotWebhookEvent) error { return nil }))
+	bot.RegisterWebhookEventCallback(ScheduleCallbackHighestPriority(func(bot *TaipeionBot, event ChatbotWebhookEvent) error {
+		calls++
+		if failing {
+			return errors.New("llm is down")
+		}
+		return nil
+	}).Named("flaky").WithRetry(3))
+
+	if err := bot.executeEventHandler(bot.handlersCtx, bot.eventHandlers[1], textEvent(1, "hello"), ""); err == nil {
+		t.Fatalf("Expected the handler to fail")
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+
+	letters := bot.DeadLetters()
+	if len(letters) != 1 || letters[0].Handler != "flaky" || letters[0].HandlerIndex != 1 || letters[0].Attempts != 3 {
+		t.Fatalf("Expected the event to be parked, got %#v", letters)
+	}
+
+	// Replay once the handler recovers.
+	failing = false
+	if err := bot.ReplayDeadLetter(letters[0].Id); err != nil {
+		t.Fatalf("Unable to replay: %s", err)
+	}
+	bot.runningHandlers.Wait()
+	if len(bot.DeadLetters()) != 0 {
+		t.Errorf("Expected the replayed letter to be removed")
+	}
+}
+
+func TestNoRetryByDefault(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	bot.retry.Backoff = time.Millisecond
+
+	calls := 0
+	failing := func(bot *TaipeionBot, event ChatbotWebhookEvent) error {
+		calls++
+		return errors.New("llm is down")
+	}
+	bot.executeEventHandler(bot.handlersCtx, ScheduleCallbackHighestPriority(failing), textEvent(1, "hello"), "")
+	if calls != 1 {
+		t.Errorf("Expected a single attempt without opt-in, got %d", calls)
+	}
+}
+
+func TestNoRetryForPermanentErrors(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	bot.retry = RetryConfig{MaxAttempts: 1, Backoff: time.Millisecond, BackoffMax: time.Millisecond}
+
+	calls := 0
+	entry := ScheduleCallbackHighestPriority(func(bot *TaipeionBot, event ChatbotWebhookEvent) error {
+		calls++
+		return ErrNoRetry
+	}).WithRetry(3)
+	bot.executeEventHandler(bot.handlersCtx, entry, textEvent(1, "hello"), "")
+	if calls != 1 {
+		t.Errorf("Expected a single attempt, got %d", calls)
+	}
+
+	// Messages rejected by TaipeiON for good are not sent again.
+	var fetches atomic.Int64
+	bot = sendTestBot(t, &fetches, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	calls = 0
+	entry = ScheduleCallbackHighestPriority(func(bot *TaipeionBot, event ChatbotWebhookEvent) error {
+		calls++
+		return bot.SendPrivateMessage("citizen", "hello", 1)
+	}).WithRetry(3)
+	err := bot.executeEventHandler(bot.handlersCtx, entry, textEvent(1, "hello"), "")
+	if !errors.Is(err, tp.ErrSendPayloadRejected) || calls != 1 {
+		t.Errorf("Expected a single attempt failing with a rejected payload, got %d attempts: %v", calls, err)
+	}
+}
+
+func TestReplayAmbiguousHandler(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	noop := func(bot *TaipeionBot, event ChatbotWebhookEvent) error { return nil }
+	bot.RegisterWebhookEventCallback(ScheduleCallbackHighestPriority(noop)) // Unnamed, both derive the same name.
+	bot.RegisterWebhookEventCallback(ScheduleCallbackHighestPriority(noop))
+	bot.deadLetters.put(DeadLetter{Id: "abc", Handler: bot.eventHandlers[1].Name, HandlerIndex: 1, Event: textEvent(1, "hello")})
+
+	if err := bot.ReplayDeadLetter("abc"); !errors.Is(err, ErrHandlerAmbiguous) {
+		t.Errorf("Expected ErrHandlerAmbiguous, got %v", err)
+	}
+}
+
+func TestDeadLetterStoreOpenError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0o644)
+
+	if _, err := NewChatbotFromConfig(ServerConfig{DeadLetter: DeadLetterConfig{Path: filepath.Join(file, "dead-letters")}}); err == nil {
+		t.Errorf("Expected an unusable dead-letter directory to be rejected")
+	}
+}
+
+func TestDeadLetterStorePersistence(t *testing.T) {
+	config := DeadLetterConfig{Path: t.TempDir(), MaxEntries: 2}
+	store, err := openDeadLetterStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, id := range []string{"a", "b", "c"} {
+		letter := DeadLetter{Id: id, Handler: "llm", Event: textEvent(1, id), FailedAt: time.Unix(int64(i), 0)}
+		if err := store.put(letter); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reopened, err := openDeadLetterStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	letters := reopened.list()
+	if len(letters) != 2 || letters[0].Id != "b" || letters[1].Event.Message.Text != "c" {
+		t.Errorf("Expected the oldest letter to be evicted, got %#v", letters)
+	}
+}
+
+func TestAdminDeadLetterEndpoints(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	bot.adminPath = "/admin"
+	bot.adminToken = "secret"
+	bot.deadLetters.put(DeadLetter{Id: "abc", Handler: "llm", Event: textEvent(1, "hello")})
+
+	mux, err := bot.webhookServeMux()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method string, path string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if code := request("GET", "/admin/dead-letters", "wrong").Code; code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a wrong token, got %d", code)
+	}
+
+	recorder := request("GET", "/admin/dead-letters/abc", "secret")
+	var letter DeadLetter
+	if err := json.NewDecoder(recorder.Body).Decode(&letter); err != nil || letter.Handler != "llm" {
+		t.Errorf("Expected the dead letter, got %d %v", recorder.Code, err)
+	}
+
+	if code := request("POST", "/admin/dead-letters/abc/replay", "secret").Code; code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an unregistered handler, got %d", code)
+	}
+	if code := request("DELETE", "/admin/dead-letters/abc", "secret").Code; code != http.StatusOK {
+		t.Errorf("Expected 200 on delete, got %d", code)
+	}
+	if code := request("GET", "/admin/dead-letters/abc", "secret").Code; code != http.StatusNotFound {
+		t.Errorf("Expected 404 after delete, got %d", code)
+	}
+}
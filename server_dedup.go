@@ -0,0 +1,118 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDedupWindow     = 10 * time.Minute
+	defaultDedupMaxEntries = 10000
+)
+
+// # Deduplication Configuration
+type DedupConfig struct {
+	Disabled   bool          `yaml:"disabled"`    // Disable the deduplication.
+	Window     time.Duration `yaml:"window"`      // How long a delivered event is remembered.
+	MaxEntries int           `yaml:"max-entries"` // Maximum number of remembered events.
+}
+
+type dedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+// # Event Deduplicator
+//
+// Remember the keys of recently accepted events, so that redelivered webhooks are suppressed.
+// Entries expire after the window, and the oldest entries are evicted once the cache is full.
+type eventDeduplicator struct {
+	mu         sync.Mutex
+	window     time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // Entries in insertion order, the oldest at the front.
+}
+
+// # New Event Deduplicator
+//
+// Create a deduplicator from configuration, returns nil if disabled.
+func newEventDeduplicator(config DedupConfig) *eventDeduplicator {
+	if config.Disabled {
+		return nil
+	}
+
+	d := &eventDeduplicator{
+		window:     config.Window,
+		maxEntries: config.MaxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+	if d.window <= 0 {
+		d.window = defaultDedupWindow
+	}
+	if d.maxEntries <= 0 {
+		d.maxEntries = defaultDedupMaxEntries
+	}
+	return d
+}
+
+// # Event Deduplication Key
+//
+// The key is the message ID if present, otherwise it's derived from the user, the timestamp and the text.
+func eventDedupKey(event ChatbotWebhookEvent) string {
+	if event.Message.Id != "" {
+		return fmt.Sprintf("%d/id/%s", event.Destination, event.Message.Id)
+	}
+
+	text_hash := sha256.Sum256([]byte(event.Message.Text))
+	return fmt.Sprintf("%d/%s/%s/%d/%x", event.Destination, event.Type, event.Source.UserId, event.Timestamp, text_hash[:8])
+}
+
+// Remove expired entries from the front.
+// Must be called with the lock held.
+func (d *eventDeduplicator) expire(now time.Time) {
+	for front := d.order.Front(); front != nil; front = d.order.Front() {
+		entry := front.Value.(dedupEntry)
+		if now.Before(entry.expires) && d.order.Len() <= d.maxEntries {
+			return
+		}
+		d.order.Remove(front)
+		delete(d.entries, entry.key)
+	}
+}
+
+// # Claim a Key
+//
+// Record the key, returns false if it was already seen within the window.
+func (d *eventDeduplicator) claim(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.expire(now)
+
+	if _, ok := d.entries[key]; ok {
+		return false
+	}
+
+	d.entries[key] = d.order.PushBack(dedupEntry{key: key, expires: now.Add(d.window)})
+	d.expire(now) // Evict the oldest entry if over capacity.
+	return true
+}
+
+// # Forget a Key
+//
+// Remove a claimed key, for events that were not accepted after all.
+func (d *eventDeduplicator) forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if element, ok := d.entries[key]; ok {
+		d.order.Remove(element)
+		delete(d.entries, key)
+	}
+}
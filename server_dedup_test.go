@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEventDeduplication(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	bot.eventQueue = newChannelEventQueue(10)
+
+	event := textEvent(1, "hello")
+	event.Message.Id = "message-1"
+
+	for i := 0; i < 3; i++ {
+		bot.enqueueWebhookIncomingEvent(context.Background(), event)
+	}
+
+	// Same text without ID, told apart by the timestamp.
+	for _, timestamp := range []int{1, 1, 2} {
+		event := textEvent(1, "hello")
+		event.Timestamp = timestamp
+		bot.enqueueWebhookIncomingEvent(context.Background(), event)
+	}
+
+	if bot.eventQueue.Len() != 3 {
+		t.Errorf("Expected 3 queued events, got %d", bot.eventQueue.Len())
+	}
+	if suppressed := bot.Metrics.Get("webhook_duplicate_suppressed_total"); suppressed != 3 {
+		t.Errorf("Expected 3 suppressed duplicates, got %d", suppressed)
+	}
+}
+
+func TestEventDeduplicatorBounds(t *testing.T) {
+	d := newEventDeduplicator(DedupConfig{Window: 20 * time.Millisecond, MaxEntries: 2})
+
+	d.claim("a")
+	d.claim("b")
+	d.claim("c") // Evicts "a".
+
+	if !d.claim("a") {
+		t.Errorf("Expected evicted key to be claimable")
+	}
+	if d.claim("c") {
+		t.Errorf("Expected recent key to be rejected")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !d.claim("c") {
+		t.Errorf("Expected expired key to be claimable")
+	}
+}
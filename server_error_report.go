@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	tp "taipeion/core"
+)
+
+const (
+	defaultErrorReplyMessage    = "抱歉，處理您的訊息時發生錯誤，請稍後再試。"
+	defaultOperatorNotifyPeriod = time.Minute
+	errorReportSendTimeout      = 10 * time.Second
+)
+
+// # Error Report Configuration
+type ErrorReportConfig struct {
+	OperatorUser     string        `yaml:"operator-user"`            // User notified of handler failures, disabled if empty.
+	OperatorChannel  int           `yaml:"operator-channel"`         // Channel used to notify the operator.
+	OperatorInterval time.Duration `yaml:"operator-notify-interval"` // Minimum interval between two notifications.
+	ReplyOnError     bool          `yaml:"reply-on-error"`           // Tell the affected user something went wrong.
+	ReplyMessage     string        `yaml:"error-reply-message"`      // The message sent to the affected user.
+}
+
+// # Handler Panic Error
+//
+// A panic recovered from an event handler, matches `ErrHandlerPanic`.
+type PanicError struct {
+	Value any    // The value passed to `panic`.
+	Stack []byte // Stack trace of the panicking goroutine.
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s: %v", ErrHandlerPanic, e.Value)
+}
+
+func (e *PanicError) Is(target error) bool {
+	return target == ErrHandlerPanic
+}
+
+// # Handler Error
+//
+// A failed handler execution, as passed to the error sinks.
+type HandlerError struct {
+	Handler   string              // Name of the handler.
+	RequestId string              // ID of the webhook request the event was received with.
+	Event     ChatbotWebhookEvent // The event being handled.
+	Err       error               // The returned error, a `*PanicError` if the handler panicked.
+	Attempts  int                 // Number of calls made before giving up.
+}
+
+// # Stack Trace
+//
+// Get the stack trace if the handler panicked, nil otherwise.
+func (e HandlerError) Stack() []byte {
+	var panic_err *PanicError
+	if errors.As(e.Err, &panic_err) {
+		return panic_err.Stack
+	}
+	return nil
+}
+
+// # Error Sink
+//
+// Receive the errors returned by, and the panics recovered from, the event handlers.
+// Sinks are called in the goroutine of the handler, once the retries are exhausted.
+type ErrorSink func(bot *TaipeionBot, herr HandlerError)
+
+// # Error Sink Registration
+//
+// Add error sinks, every sink receives every error.
+func (tpb *TaipeionBot) AddErrorSink(sinks ...ErrorSink) {
+	tpb.errorSinks = append(tpb.errorSinks, sinks...)
+}
+
+// # Log Error Sink
+//
+// Log the error, with the stack trace if the handler panicked.
+func LogErrorSink() ErrorSink {
+	return func(bot *TaipeionBot, herr HandlerError) {
+		if stack := herr.Stack(); stack != nil {
+			log.Printf("[EvProcessor] Error: Handler (%s) panicked on request (%s): %s\n%s", herr.Handler, herr.RequestId, herr.Err, stack)
+			return
+		}
+		log.Printf("[EvProcessor] Error: Handler (%s) failed on request (%s): %s\n", herr.Handler, herr.RequestId, herr.Err)
+	}
+}
+
+// # Metrics Error Sink
+//
+// Count errors and panics.
+func MetricsErrorSink() ErrorSink {
+	return func(bot *TaipeionBot, herr HandlerError) {
+		bot.Metrics.Inc("handler_errors_total")
+		if errors.Is(herr.Err, ErrHandlerPanic) {
+			bot.Metrics.Inc("handler_panics_total")
+		}
+	}
+}
+
+// # Operator Notification Sink
+//
+// Send a private message describing the error to an operator.
+// At most one notification is sent per interval, the suppressed ones are counted in the next message.
+func OperatorNotificationSink(operatorUser string, operatorChannel int, interval time.Duration) ErrorSink {
+	if interval <= 0 {
+		interval = defaultOperatorNotifyPeriod
+	}
+
+	var mu sync.Mutex
+	var last_sent time.Time
+	suppressed := 0
+
+	return func(bot *TaipeionBot, herr HandlerError) {
+		mu.Lock()
+		if time.Since(last_sent) < interval {
+			suppressed++
+			mu.Unlock()
+			return
+		}
+		last_sent = time.Now()
+		skipped := suppressed
+		suppressed = 0
+		mu.Unlock()
+
+		message := fmt.Sprintf("[Chatbot] Handler %s failed on channel %d (user: %s, request: %s):\n%s",
+			herr.Handler, herr.Event.Destination, herr.Event.Source.UserId, herr.RequestId, herr.Err)
+		if skipped > 0 {
+			message += fmt.Sprintf("\n(%d more errors since the last notification)", skipped)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), errorReportSendTimeout)
+		defer cancel()
+		if err := bot.SendPrivateMessageContext(ctx, operatorUser, message, operatorChannel); err != nil {
+			log.Println("[EvProcessor] Error: Unable to notify the operator:", err)
+		}
+	}
+}
+
+// # Error Reply Sink
+//
+// Tell the user who sent the message that something went wrong.
+// Nothing is sent for events without a message, or if the handler was cancelled by the shutdown.
+func ErrorReplySink(message string) ErrorSink {
+	if message == "" {
+		message = defaultErrorReplyMessage
+	}
+
+	return func(bot *TaipeionBot, herr HandlerError) {
+		event := herr.Event
+		if event.Type != tp.EventTypeMessage || event.Source.UserId == "" || errors.Is(herr.Err, context.Canceled) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), errorReportSendTimeout)
+		defer cancel()
+		if err := bot.SendPrivateMessageContext(ctx, event.Source.UserId, message, event.Destination); err != nil {
+			log.Println("[EvProcessor] Error: Unable to send the error reply:", err)
+		}
+	}
+}
+
+// # Run Event Handler
+//
+// Call the handler once, turning a panic into a `*PanicError`.
+// A panicking handler never takes the process down.
+func (tpb *TaipeionBot) runEventHandler(ctx context.Context, entry eventHandlerEntry, event ChatbotWebhookEvent) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = &PanicError{Value: recovered, Stack: debug.Stack()}
+		}
+	}()
+
+	return tpb.eventProcessorInternalCallbackWrapper(ctx, entry, event)
+}
+
+// Pass the error to every sink, a panicking sink is logged and skipped.
+func (tpb *TaipeionBot) reportHandlerError(herr HandlerError) {
+	for _, sink := range tpb.errorSinks {
+		func() {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					log.Printf("[EvProcessor] Error: Error sink panicked: %v\n", recovered)
+				}
+			}()
+			sink(tpb, herr)
+		}()
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerPanicIsReported(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	bot.retry.MaxAttempts = 1
+
+	var reported []HandlerError
+	bot.AddErrorSink(func(bot *TaipeionBot, herr HandlerError) { reported = append(reported, herr) })
+
+	panicking := ScheduleCallbackHighestPriority(func(bot *TaipeionBot, event ChatbotWebhookEvent) error {
+		panic("boom")
+	}).Named("panicking")
+	failing := ScheduleCallbackHighestPriority(func(bot *TaipeionBot, event ChatbotWebhookEvent) error {
+		return errors.New("failed")
+	}).Named("failing")
+
+	event := textEvent(1, "hello")
+	if err := bot.executeEventHandler(bot.handlersCtx, panicking, event, ""); !errors.Is(err, ErrHandlerPanic) {
+		t.Errorf("Expected ErrHandlerPanic, got %v", err)
+	}
+	bot.executeEventHandler(bot.handlersCtx, failing, event, "")
+
+	if len(reported) != 2 {
+		t.Fatalf("Expected 2 reported errors, got %d", len(reported))
+	}
+	if reported[0].Handler != "panicking" || !strings.Contains(string(reported[0].Stack()), "TestHandlerPanicIsReported") {
+		t.Errorf("Expected the panic with its stack trace, got %#v", reported[0])
+	}
+	if reported[1].Handler != "failing" || reported[1].Stack() != nil {
+		t.Errorf("Expected the returned error without stack trace, got %#v", reported[1])
+	}
+	if bot.Metrics.Get("handler_errors_total") != 2 || bot.Metrics.Get("handler_panics_total") != 1 {
+		t.Errorf("Expected errors and panics to be counted, got %v", bot.Metrics.Snapshot())
+	}
+}
+
+func TestTimeoutMiddlewareRecoversPanic(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	entry := ScheduleCallbackHighestPriority(func(bot *TaipeionBot, event ChatbotWebhookEvent) error {
+		panic("boom")
+	}).With(TimeoutMiddleware(time.Second))
+
+	if err := bot.runEventHandler(bot.handlersCtx, entry, textEvent(1, "hello")); !errors.Is(err, ErrHandlerPanic) {
+		t.Errorf("Expected ErrHandlerPanic, got %v", err)
+	}
+}
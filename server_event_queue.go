@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultEventQueueCapacity = 100
+
+	EventQueueMemory = "memory" // In-memory queue, lost upon restart.
+	EventQueueFile   = "file"   // File-backed queue, replayed upon restart.
+)
+
+// # Event Queue Configuration
+type EventQueueConfig struct {
+	Type     string `yaml:"type"`     // Type of the queue, `memory` (default) or `file`.
+	Capacity int    `yaml:"capacity"` // Maximum number of events waiting in the queue.
+	Path     string `yaml:"path"`     // Path of the log file, for the `file` queue.
+
+	OverflowPolicy  string        `yaml:"overflow-policy"`  // What to do when the queue is full, see `Overflow*`.
+	OverflowTimeout time.Duration `yaml:"overflow-timeout"` // How long to wait for room with the `block` policy.
+	BusyMessage     string        `yaml:"busy-message"`     // Reply of the `reply-busy` policy.
+}
+
+// # Queued Event
+//
+// An event along with its sequence number in the queue.
+type QueuedEvent struct {
+	Seq   uint64              `json:"seq"`   // Sequence number, used to acknowledge the event.
+	Event ChatbotWebhookEvent `json:"event"` // The event.
+}
+
+// # Event Queue
+//
+// The queue between the webhook listener and the event processor.
+// An event is kept by the queue until it is acknowledged, a durable queue may deliver
+// unacknowledged events again after a restart.
+type EventQueue interface {
+	Enqueue(ctx context.Context, event ChatbotWebhookEvent) error // Add an event, block until there is room or the context is done.
+	TryEnqueue(event ChatbotWebhookEvent) (bool, error)           // Add an event if there is room, never blocks.
+	Dequeue(ctx context.Context) (QueuedEvent, error)             // Take the next event, block until available or the context is done.
+	TryDequeue() (QueuedEvent, bool)                              // Take the next event if any, never blocks.
+	DropOldest() (QueuedEvent, bool)                              // Discard the next event if any, never blocks.
+	Ack(seq uint64) error                                         // Mark an event as processed.
+	Len() int                                                     // Number of events waiting in the queue.
+	Close() error                                                 // Release the resources of the queue.
+}
+
+// # New Event Queue
+//
+// Create an event queue from configuration.
+func NewEventQueue(config EventQueueConfig) (EventQueue, error) {
+	capacity := config.Capacity
+	if capacity <= 0 {
+		capacity = defaultEventQueueCapacity
+	}
+
+	switch config.Type {
+	case "", EventQueueMemory:
+		return newChannelEventQueue(capacity), nil
+	case EventQueueFile:
+		return openFileEventQueue(config.Path, capacity)
+	default:
+		return nil, fmt.Errorf("unknown event queue type: %q", config.Type)
+	}
+}
+
+// # Channel Event Queue
+//
+// The default in-memory queue, backed by a buffered channel.
+type channelEventQueue struct {
+	events  chan QueuedEvent
+	lastSeq uint64
+}
+
+func newChannelEventQueue(capacity int) *channelEventQueue {
+	return &channelEventQueue{
+		events: make(chan QueuedEvent, capacity),
+	}
+}
+
+func (q *channelEventQueue) Enqueue(ctx context.Context, event ChatbotWebhookEvent) error {
+	queued := QueuedEvent{Seq: atomic.AddUint64(&q.lastSeq, 1), Event: event}
+	select {
+	case q.events <- queued:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *channelEventQueue) TryEnqueue(event ChatbotWebhookEvent) (bool, error) {
+	queued := QueuedEvent{Seq: atomic.AddUint64(&q.lastSeq, 1), Event: event}
+	select {
+	case q.events <- queued:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (q *channelEventQueue) Dequeue(ctx context.Context) (QueuedEvent, error) {
+	select {
+	case queued := <-q.events:
+		return queued, nil
+	case <-ctx.Done():
+		return QueuedEvent{}, ctx.Err()
+	}
+}
+
+func (q *channelEventQueue) TryDequeue() (QueuedEvent, bool) {
+	select {
+	case queued := <-q.events:
+		return queued, true
+	default:
+		return QueuedEvent{}, false
+	}
+}
+
+func (q *channelEventQueue) DropOldest() (QueuedEvent, bool) {
+	return q.TryDequeue() // Nothing else to clean up.
+}
+
+func (q *channelEventQueue) Ack(seq uint64) error { return nil } // Nothing to keep track of.
+
+func (q *channelEventQueue) Len() int { return len(q.events) }
+
+func (q *channelEventQueue) Close() error { return nil }
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const fileQueueCompactThreshold = 1000 // Minimum number of records in the log before it is compacted.
+
+var ErrEventQueueClosed = errors.New("event queue closed")
+
+// A record in the queue log.
+type fileQueueRecord struct {
+	Op    string               `json:"op"`              // `put` or `ack`.
+	Seq   uint64               `json:"seq"`             // Sequence number of the event.
+	Event *ChatbotWebhookEvent `json:"event,omitempty"` // The event, for `put` records.
+}
+
+// # File Event Queue
+//
+// A durable queue backed by a write-ahead log.
+// Every accepted event is appended to the log and synced before `Enqueue` returns,
+// and every acknowledgement is appended as well. Upon opening, events without
+// acknowledgement are replayed in order.
+type fileEventQueue struct {
+	mu       sync.Mutex
+	file     *os.File
+	path     string
+	capacity int
+	closed   bool
+
+	pending  []QueuedEvent                  // Events waiting to be dequeued.
+	inflight map[uint64]ChatbotWebhookEvent // Events dequeued but not acknowledged yet.
+	lastSeq  uint64
+	records  int // Records written since the last compaction.
+
+	notEmpty chan struct{} // Signalled when an event is added.
+	notFull  chan struct{} // Signalled when an event is removed.
+}
+
+// # Open File Event Queue
+//
+// Open or create the queue log, and load the unacknowledged events.
+func openFileEventQueue(path string, capacity int) (*fileEventQueue, error) {
+	if path == "" {
+		return nil, errors.New("path of the file event queue is not set")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	q := &fileEventQueue{
+		path:     path,
+		capacity: capacity,
+		inflight: make(map[uint64]ChatbotWebhookEvent),
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+	}
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	// Rewrite the log with the pending events only.
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+
+	if len(q.pending) > 0 {
+		log.Printf("[EvQueue] Replaying %d unprocessed events from %s.\n", len(q.pending), path)
+	}
+
+	return q, nil
+}
+
+// Read the log and rebuild the pending events.
+func (q *fileEventQueue) load() error {
+	file, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // Fresh queue.
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	events := make(map[uint64]ChatbotWebhookEvent)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record fileQueueRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A torn write at the end of the log, the event was never accepted.
+			log.Printf("[EvQueue] Warning: Skipping malformed record at %s:%d: %s\n", q.path, line, err)
+			continue
+		}
+
+		q.lastSeq = max(q.lastSeq, record.Seq)
+		switch record.Op {
+		case "put":
+			if record.Event != nil {
+				events[record.Seq] = *record.Event
+			}
+		case "ack":
+			delete(events, record.Seq)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for seq, event := range events {
+		q.pending = append(q.pending, QueuedEvent{Seq: seq, Event: event})
+	}
+	sort.Slice(q.pending, func(i, j int) bool { return q.pending[i].Seq < q.pending[j].Seq })
+
+	return nil
+}
+
+// Rewrite the log with the events not acknowledged yet.
+// Must be called with the lock held, or before the queue is shared.
+func (q *fileEventQueue) compact() error {
+	live := make([]QueuedEvent, 0, len(q.inflight)+len(q.pending))
+	for seq, event := range q.inflight {
+		live = append(live, QueuedEvent{Seq: seq, Event: event})
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].Seq < live[j].Seq })
+	live = append(live, q.pending...)
+
+	tmp_path := q.path + ".tmp"
+	tmp, err := os.OpenFile(tmp_path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, queued := range live {
+		if err := encoder.Encode(fileQueueRecord{Op: "put", Seq: queued.Seq, Event: &queued.Event}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp_path, q.path); err != nil {
+		return err
+	}
+
+	if q.file != nil {
+		q.file.Close()
+	}
+	q.file, err = os.OpenFile(q.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	q.records = len(live)
+	return err
+}
+
+// Append a record to the log and sync it.
+// Must be called with the lock held.
+func (q *fileEventQueue) appendRecord(record fileQueueRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := q.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	q.records++
+	return q.file.Sync()
+}
+
+// Wake up one waiter, if any.
+func notifyWaiter(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (q *fileEventQueue) Enqueue(ctx context.Context, event ChatbotWebhookEvent) error {
+	for {
+		ok, err := q.TryEnqueue(event)
+		if ok || err != nil {
+			return err
+		}
+
+		select { // Wait for room.
+		case <-q.notFull:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (q *fileEventQueue) TryEnqueue(event ChatbotWebhookEvent) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false, ErrEventQueueClosed
+	}
+
+	if len(q.pending) >= q.capacity {
+		return false, nil
+	}
+
+	seq := q.lastSeq + 1
+	if err := q.appendRecord(fileQueueRecord{Op: "put", Seq: seq, Event: &event}); err != nil {
+		return false, fmt.Errorf("unable to persist event: %w", err)
+	}
+	q.lastSeq = seq
+	q.pending = append(q.pending, QueuedEvent{Seq: seq, Event: event})
+
+	notifyWaiter(q.notEmpty)
+	return true, nil
+}
+
+// Take the head of the pending events.
+// Must be called with the lock held.
+func (q *fileEventQueue) pop() QueuedEvent {
+	queued := q.pending[0]
+	q.pending = q.pending[1:]
+	q.inflight[queued.Seq] = queued.Event
+
+	notifyWaiter(q.notFull)
+	if len(q.pending) > 0 {
+		notifyWaiter(q.notEmpty) // Let the next waiter in.
+	}
+	return queued
+}
+
+func (q *fileEventQueue) Dequeue(ctx context.Context) (QueuedEvent, error) {
+	for {
+		if queued, ok := q.TryDequeue(); ok {
+			return queued, nil
+		}
+
+		select { // Wait for events.
+		case <-q.notEmpty:
+		case <-ctx.Done():
+			return QueuedEvent{}, ctx.Err()
+		}
+	}
+}
+
+func (q *fileEventQueue) TryDequeue() (QueuedEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || len(q.pending) == 0 {
+		return QueuedEvent{}, false
+	}
+	return q.pop(), true
+}
+
+func (q *fileEventQueue) DropOldest() (QueuedEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || len(q.pending) == 0 {
+		return QueuedEvent{}, false
+	}
+
+	queued := q.pop()
+	if err := q.ack(queued.Seq); err != nil {
+		log.Printf("[EvQueue] Error: Unable to record dropped event (%d): %s\n", queued.Seq, err)
+	}
+	return queued, true
+}
+
+func (q *fileEventQueue) Ack(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.ack(seq)
+}
+
+// Acknowledge an event.
+// Must be called with the lock held.
+func (q *fileEventQueue) ack(seq uint64) error {
+	if q.closed {
+		return ErrEventQueueClosed
+	}
+
+	if _, ok := q.inflight[seq]; !ok {
+		return nil // Unknown or already acknowledged.
+	}
+	delete(q.inflight, seq)
+
+	if err := q.appendRecord(fileQueueRecord{Op: "ack", Seq: seq}); err != nil {
+		return err
+	}
+
+	// Rewrite the log once it is mostly made of processed events.
+	if q.records >= fileQueueCompactThreshold && q.records > 2*(len(q.pending)+len(q.inflight)) {
+		return q.compact()
+	}
+	return nil
+}
+
+func (q *fileEventQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+func (q *fileEventQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+
+	if remaining := len(q.pending) + len(q.inflight); remaining > 0 {
+		log.Printf("[EvQueue] %d unprocessed events kept in %s for replay.\n", remaining, q.path)
+	}
+	return q.file.Close()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	OverflowBlock      = "block"       // Wait for room until the timeout, then reject the webhook.
+	OverflowDropOldest = "drop-oldest" // Discard the oldest queued event to make room.
+	OverflowDropNewest = "drop-newest" // Discard the incoming event.
+	OverflowReplyBusy  = "reply-busy"  // Discard the incoming event and tell the user to try later.
+
+	defaultOverflowTimeout = 5 * time.Second
+	defaultBusyMessage     = "目前使用人數眾多，請稍後再試。"
+)
+
+// The event was not accepted because the queue is full.
+var ErrEventQueueFull = errors.New("event queue is full")
+
+// # Event Overflow Policy
+//
+// Decide what happens to an incoming event when the queue is full.
+type overflowPolicy struct {
+	Policy      string
+	Timeout     time.Duration
+	BusyMessage string
+}
+
+// # New Overflow Policy
+//
+// Create an overflow policy from the queue configuration, zero values fall back to defaults.
+func newOverflowPolicy(config EventQueueConfig) (overflowPolicy, error) {
+	policy := overflowPolicy{
+		Policy:      config.OverflowPolicy,
+		Timeout:     config.OverflowTimeout,
+		BusyMessage: config.BusyMessage,
+	}
+
+	switch policy.Policy {
+	case "":
+		policy.Policy = OverflowBlock
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest, OverflowReplyBusy:
+	default:
+		return policy, fmt.Errorf("unknown overflow policy: %q", policy.Policy)
+	}
+
+	if policy.Timeout <= 0 {
+		policy.Timeout = defaultOverflowTimeout
+	}
+	if policy.BusyMessage == "" {
+		policy.BusyMessage = defaultBusyMessage
+	}
+	return policy, nil
+}
+
+// Report an overflow.
+func (tpb *TaipeionBot) reportQueueOverflow(event ChatbotWebhookEvent, action string) {
+	tpb.Metrics.Inc("event_queue_overflow_total")
+	tpb.Metrics.Inc(fmt.Sprintf("event_queue_overflow_%s_total", tpb.overflow.Policy))
+	log.Printf("[EvHandler] Warning: Event queue is full (policy: %s), %s event from user (%s) on channel (%d).\n",
+		tpb.overflow.Policy, action, event.Source.UserId, event.Destination)
+}
+
+// # Accept an Incoming Event
+//
+// Put the event into the queue, applying the overflow policy if the queue is full.
+// Returns `ErrEventQueueFull` only if the webhook should be rejected.
+func (tpb *TaipeionBot) acceptEvent(ctx context.Context, event ChatbotWebhookEvent) error {
+	if tpb.overflow.Policy == OverflowBlock {
+		ok, err := tpb.eventQueue.TryEnqueue(event)
+		if ok || err != nil {
+			return err
+		}
+
+		// Wait for room.
+		ctx, cancel := context.WithTimeout(ctx, tpb.overflow.Timeout)
+		defer cancel()
+		if err := tpb.eventQueue.Enqueue(ctx, event); err != nil {
+			tpb.reportQueueOverflow(event, "rejected")
+			return ErrEventQueueFull
+		}
+		return nil
+	}
+
+	for {
+		ok, err := tpb.eventQueue.TryEnqueue(event)
+		if ok || err != nil {
+			return err
+		}
+
+		switch tpb.overflow.Policy {
+		case OverflowDropOldest:
+			if dropped, ok := tpb.eventQueue.DropOldest(); ok {
+				tpb.reportQueueOverflow(dropped.Event, "dropped oldest")
+			}
+			continue // Try again with the room made.
+
+		case OverflowReplyBusy:
+			tpb.reportQueueOverflow(event, "replied busy to")
+			if user_id := event.Source.UserId; user_id != "" {
+				tpb.sendPrivateMessageInBackground(user_id, tpb.overflow.BusyMessage, event.Destination, "busy message") // Do not hold the webhook.
+			}
+
+		default: // OverflowDropNewest
+			tpb.reportQueueOverflow(event, "dropped")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tp "taipeion/core"
+)
+
+func textEvent(channel int, text string) ChatbotWebhookEvent {
+	return ChatbotWebhookEvent{
+		Destination: channel,
+		MessageEvent: tp.MessageEvent{
+			Type:    "message",
+			Message: tp.Message{Type: "text", Text: text},
+		},
+	}
+}
+
+func TestFileEventQueueReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	ctx := context.Background()
+
+	q, err := openFileEventQueue(path, 10)
+	if err != nil {
+		t.Fatalf("Error opening queue: %v", err)
+	}
+
+	for _, text := range []string{"first", "second", "third"} {
+		if err := q.Enqueue(ctx, textEvent(1, text)); err != nil {
+			t.Fatalf("Error enqueuing event: %v", err)
+		}
+	}
+
+	// Process the first event, take the second one without acknowledging it.
+	first, _ := q.Dequeue(ctx)
+	if err := q.Ack(first.Seq); err != nil {
+		t.Fatalf("Error acknowledging event: %v", err)
+	}
+	q.Dequeue(ctx)
+	q.Close()
+
+	// Reopen, the second and the third events are replayed in order.
+	q, err = openFileEventQueue(path, 10)
+	if err != nil {
+		t.Fatalf("Error reopening queue: %v", err)
+	}
+	defer q.Close()
+
+	if q.Len() != 2 {
+		t.Fatalf("Expected 2 replayed events, got %d", q.Len())
+	}
+
+	for _, expected := range []string{"second", "third"} {
+		queued, ok := q.TryDequeue()
+		if !ok || queued.Event.Message.Text != expected {
+			t.Errorf("Expected replayed event %q, got %#v", expected, queued)
+		}
+	}
+
+	// New events continue the sequence.
+	q.Enqueue(ctx, textEvent(1, "fourth"))
+	if queued, _ := q.TryDequeue(); queued.Seq != 4 {
+		t.Errorf("Expected sequence 4, got %d", queued.Seq)
+	}
+}
+
+func TestFileEventQueueCapacity(t *testing.T) {
+	q, err := openFileEventQueue(filepath.Join(t.TempDir(), "events.log"), 1)
+	if err != nil {
+		t.Fatalf("Error opening queue: %v", err)
+	}
+	defer q.Close()
+
+	q.Enqueue(context.Background(), textEvent(1, "first"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := q.Enqueue(ctx, textEvent(1, "second")); err == nil {
+		t.Errorf("Expected enqueue on a full queue to time out")
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	ctx := context.Background()
+
+	for _, c := range []struct {
+		policy   string
+		expected string // Text of the event left in the queue.
+		err      error
+	}{
+		{OverflowDropOldest, "second", nil},
+		{OverflowDropNewest, "first", nil},
+		{OverflowBlock, "first", ErrEventQueueFull},
+	} {
+		bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+		bot.eventQueue = newChannelEventQueue(1)
+		bot.overflow, _ = newOverflowPolicy(EventQueueConfig{OverflowPolicy: c.policy, OverflowTimeout: 10 * time.Millisecond})
+
+		bot.acceptEvent(ctx, textEvent(1, "first"))
+		if err := bot.acceptEvent(ctx, textEvent(1, "second")); err != c.err {
+			t.Errorf("%s: expected error %v, got %v", c.policy, c.err, err)
+		}
+
+		if queued, _ := bot.eventQueue.TryDequeue(); queued.Event.Message.Text != c.expected {
+			t.Errorf("%s: expected %q in queue, got %q", c.policy, c.expected, queued.Event.Message.Text)
+		}
+		if bot.Metrics.Get("event_queue_overflow_total") != 1 {
+			t.Errorf("%s: expected overflow to be counted", c.policy)
+		}
+	}
+}
+
+func TestUnknownOverflowPolicy(t *testing.T) {
+	if _, err := NewChatbotFromConfig(ServerConfig{EventQueue: EventQueueConfig{OverflowPolicy: "drop_oldest"}}); err == nil {
+		t.Errorf("Expected an unknown overflow policy to be rejected")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	tp "taipeion/core"
+)
+
+// Registration shortcuts per event type.
+// Message callbacks are scheduled with normal priority, the other kinds with high priority
+// so that e.g. welcome messages are not queued behind LLM questions.
+// Use `RegisterWebhookEventCallback` with `ForEventTypes` for other priorities.
+
+// # Message Event Registration
+func (tpb *TaipeionBot) OnMessage(callback WebhookEventCallback) {
+	tpb.RegisterWebhookEventCallback(ScheduleCallbackNormalPriority(callback).ForEventTypes(tp.EventTypeMessage))
+}
+
+// # Follow Event Registration
+//
+// The callback is called when a user subscribes to the channel.
+func (tpb *TaipeionBot) OnFollow(callback WebhookEventCallback) {
+	tpb.RegisterWebhookEventCallback(ScheduleCallbackWithPriority(callback, PriorityHigh).ForEventTypes(tp.EventTypeFollow))
+}
+
+// # Unfollow Event Registration
+//
+// The callback is called when a user unsubscribes from the channel.
+func (tpb *TaipeionBot) OnUnfollow(callback WebhookEventCallback) {
+	tpb.RegisterWebhookEventCallback(ScheduleCallbackWithPriority(callback, PriorityHigh).ForEventTypes(tp.EventTypeUnfollow))
+}
+
+// # Join Event Registration
+func (tpb *TaipeionBot) OnJoin(callback WebhookEventCallback) {
+	tpb.RegisterWebhookEventCallback(ScheduleCallbackWithPriority(callback, PriorityHigh).ForEventTypes(tp.EventTypeJoin))
+}
+
+// # Leave Event Registration
+func (tpb *TaipeionBot) OnLeave(callback WebhookEventCallback) {
+	tpb.RegisterWebhookEventCallback(ScheduleCallbackWithPriority(callback, PriorityHigh).ForEventTypes(tp.EventTypeLeave))
+}
+
+// # Postback Event Registration
+//
+// The callback is called when a user taps a postback action, the data is in `event.Postback`.
+func (tpb *TaipeionBot) OnPostback(callback WebhookEventCallback) {
+	tpb.RegisterWebhookEventCallback(ScheduleCallbackWithPriority(callback, PriorityHigh).ForEventTypes(tp.EventTypePostback))
+}
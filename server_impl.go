@@ -1,94 +1,171 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	tp "taipeion/core"
-
-	api_platform "github.com/h-alice/tcg-api-platform-client"
-
-	"golang.org/x/sync/semaphore"
 )
 
 // # Enqueue an incoming webhook event.
-func (tpb *TaipeionBot) enqueueWebhookIncomingEvent(event ChatbotWebhookEvent) {
-	tpb.eventQueue <- event
+//
+// Redelivered events are suppressed.
+func (tpb *TaipeionBot) enqueueWebhookIncomingEvent(ctx context.Context, event ChatbotWebhookEvent) error {
+	if tpb.dedup == nil {
+		return tpb.acceptEvent(ctx, event)
+	}
+
+	key := eventDedupKey(event)
+	if !tpb.dedup.claim(key) {
+		log.Printf("[EvHandler] Suppressed duplicate event (%s).\n", key)
+		tpb.Metrics.Inc("webhook_duplicate_suppressed_total")
+		return nil
+	}
+
+	err := tpb.acceptEvent(ctx, event)
+	if err != nil {
+		tpb.dedup.forget(key) // Let the redelivery through.
+	}
+	return err
 }
 
 // # Broadcast message sender
 //
 // This method uses the message API to send a broadcast message to all users who have subscribed to the channel.
+// Long messages are sent in several parts, see `MessageSplitConfig`.
 //
 // Parameters:
 // - message: The message to be sent.
 // - target_channel: The channel's ID to send the message to.
 func (tpb *TaipeionBot) SendBroadcastMessage(message string, target_channel int) error {
-	// Craft the message
-	ch_payload := tp.ChannelMessagePayload{
-		Ask: "broadcastMessage",
-		Message: tp.Message{
-			Type: "text",
-			Text: message,
-		},
-	}
-
-	// Send the message
-	return tpb.DoEndpointPostRequest(tpb.Endpoint, ch_payload, target_channel)
+	return tpb.SendBroadcastMessageContext(context.Background(), message, target_channel)
 }
 
 // # Private message sender
 //
 // This method uses the message API to send a private message to a user.
+// Long messages are sent in several parts, see `MessageSplitConfig`.
 //
 // Parameters:
 // - userId: The user's ID to send the message to.
 // - message: The message to be sent.
 // - target_channel: The channel's ID to send the message to.
 func (tpb *TaipeionBot) SendPrivateMessage(userId string, message string, target_channel int) error {
+	return tpb.SendPrivateMessageContext(context.Background(), userId, message, target_channel)
+}
+
+// # Broadcast message sender with context
+//
+// Same as `SendBroadcastMessage`, the request is aborted when the context is done.
+func (tpb *TaipeionBot) SendBroadcastMessageContext(ctx context.Context, message string, target_channel int) error {
+	return tpb.sendSplitText(ctx, tp.ChannelMessagePayload{Ask: "broadcastMessage"}, message, target_channel)
+}
+
+// # Private message sender with context
+//
+// Same as `SendPrivateMessage`, the request is aborted when the context is done.
+func (tpb *TaipeionBot) SendPrivateMessageContext(ctx context.Context, userId string, message string, target_channel int) error {
+	return tpb.sendSplitText(ctx, tp.ChannelMessagePayload{Ask: "sendMessage", Recipient: userId}, message, target_channel)
+}
+
+// # Broadcast any message
+//
+// Send a message of any type to all users who have subscribed to the channel.
+// The message is validated before sending.
+func (tpb *TaipeionBot) SendBroadcast(message tp.Message, target_channel int) error {
+	return tpb.SendBroadcastContext(context.Background(), message, target_channel)
+}
+
+// # Send any message privately
+//
+// Send a message of any type to a user.
+// The message is validated before sending.
+func (tpb *TaipeionBot) SendPrivate(userId string, message tp.Message, target_channel int) error {
+	return tpb.SendPrivateContext(context.Background(), userId, message, target_channel)
+}
+
+// # Broadcast any message with context
+//
+// Same as `SendBroadcast`, the request is aborted when the context is done.
+func (tpb *TaipeionBot) SendBroadcastContext(ctx context.Context, message tp.Message, target_channel int) error {
+	if err := message.Validate(); err != nil {
+		return err
+	}
+
+	// Craft the message
+	ch_payload := tp.ChannelMessagePayload{
+		Ask:     "broadcastMessage",
+		Message: message,
+	}
+
+	// Send the message, retried through the outbox if it fails
+	_, err := tpb.sendThroughOutbox(ctx, ch_payload, target_channel, "")
+	return err
+}
+
+// # Send any message privately with context
+//
+// Same as `SendPrivate`, the request is aborted when the context is done.
+func (tpb *TaipeionBot) SendPrivateContext(ctx context.Context, userId string, message tp.Message, target_channel int) error {
+	if err := message.Validate(); err != nil {
+		return err
+	}
+
 	// Craft the message
 	ch_payload := tp.ChannelMessagePayload{
 		Ask:       "sendMessage",
 		Recipient: userId,
-		Message: tp.Message{
-			Type: "text",
-			Text: message,
-		},
+		Message:   message,
 	}
 
-	// Send the message
-	return tpb.DoEndpointPostRequest(tpb.Endpoint, ch_payload, target_channel)
+	// Send the message, retried through the outbox if it fails
+	_, err := tpb.sendThroughOutbox(ctx, ch_payload, target_channel, "")
+	return err
+}
 
+// Send a private message without holding the caller, e.g. a notice from the webhook handler.
+// The send counts as a running handler, so that shutdown waits for it, and is cancelled with the handlers.
+func (tpb *TaipeionBot) sendPrivateMessageInBackground(userId string, message string, target_channel int, what string) {
+	tpb.runningHandlers.Add(1)
+	go func() {
+		defer tpb.runningHandlers.Done()
+		if err := tpb.SendPrivateMessageContext(tpb.handlersCtx, userId, message, target_channel); err != nil {
+			log.Printf("[ReqSender] Error: Unable to send %s to user (%s): %s\n", what, userId, err)
+		}
+	}()
 }
 
 // # Perform a POST request to the TaipeiON endpoint
 func (tpb *TaipeionBot) DoEndpointPostRequest(endpoint string, channelPayload tp.ChannelMessagePayload, target_channel int) error {
+	return tpb.DoEndpointPostRequestContext(context.Background(), endpoint, channelPayload, target_channel)
+}
 
-	_, err := tpb.api_client.RequestAccessToken()
-	if err != nil {
-		log.Printf("[ReqSender] Error: Unable to request access token: %s\n", err)
-		return err
-	}
-
-	_, err = tpb.api_client.RequestSignBlock()
-	if err != nil {
-		log.Printf("[ReqSender] Error: Unable to request sign block: %s\n", err)
-		return err
-	}
+// # Perform a POST request to the TaipeiON endpoint with context
+//
+// Messages rejected by TaipeiON are returned as `*tp.SendError`, use `tp.IsRetryableSendError` to
+// tell whether sending it again may succeed.
+func (tpb *TaipeionBot) DoEndpointPostRequestContext(ctx context.Context, endpoint string, channelPayload tp.ChannelMessagePayload, target_channel int) error {
 
 	log.Println(channelPayload)
 
-	headers := map[string]string{
-		"Content-Type": "application/json",
-		"backAuth":     tpb.Channels[target_channel].ChannelAccessToken,
+	// Wait for the outbound rate limit
+	if err := tpb.outboundLimiter.wait(ctx, target_channel); err != nil {
+		return err
 	}
 
 	// Perform the request
-	resp, err := tpb.api_client.SendRequest(tpb.Endpoint, "POST", headers, channelPayload, nil)
+	resp, err := tpb.endpointPostRequest(ctx, endpoint, channelPayload, target_channel)
 	if err != nil {
 		return err
 	}
@@ -98,13 +175,85 @@ func (tpb *TaipeionBot) DoEndpointPostRequest(endpoint string, channelPayload tp
 	body, _ := io.ReadAll(resp.Body)
 	log.Printf("[ReqSender] Response (%d): %s\n", resp.StatusCode, string(body))
 
-	return nil
+	// Tell the caller whether the message was delivered, see `tp.SendError`.
+	_, err = tp.ParseSendResponse(resp.StatusCode, resp.Header, body)
+	if err != nil {
+		log.Printf("[ReqSender] Error: Message to channel (%d) rejected: %s\n", target_channel, err)
+		tpb.Metrics.Inc("send_failures_total")
+	}
+	return err
+}
+
+// # Signed POST request to the TaipeiON endpoint
+//
+// Send the payload through the API platform with the cached credentials, see `credentialManager`.
+// The payload is signed with `ApiPlatformClient.SignPayload`, the request is built here since
+// `ApiPlatformClient.SendRequest` cannot be bound to the context.
+// Rejected credentials are refreshed and the request is sent once more, see `credentialManager.invalidate`.
+// The caller is responsible for closing the response body.
+func (tpb *TaipeionBot) endpointPostRequest(ctx context.Context, endpoint string, payload any, target_channel int) (*http.Response, error) {
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		credentials, err := tpb.credentials.get(ctx)
+		if err != nil {
+			log.Printf("[ReqSender] Error: Unable to get the API platform credentials: %s\n", err)
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+credentials.AccessToken)
+		req.Header.Set("SignCode", credentials.client.SignPayload(body))
+		req.Header.Set("backAuth", tpb.Channels[target_channel].ChannelAccessToken)
+
+		resp, err := tpb.httpClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || attempt > 1 {
+			return resp, err
+		}
+
+		tpb.Metrics.Inc("credential_rejections_total")
+		if !tpb.credentials.invalidate(credentials) {
+			log.Println("[ReqSender] Error: Request rejected with fresh credentials, check the channel access token.")
+			return resp, nil
+		}
+
+		// The credentials were revoked or expired early.
+		log.Println("[ReqSender] Credentials rejected, refreshing them.")
+		resp.Body.Close()
+	}
+}
+
+// # Webhook Signature Check
+//
+// Check the signature of a webhook body against the secret of the destination channel.
+// Webhooks for unknown channels are always rejected.
+func (tpb *TaipeionBot) verifyWebhookSignature(destination int, body []byte, signature string) bool {
+	if tpb.skipSignatureCheck {
+		return true
+	}
+
+	channel, ok := tpb.Channels[destination]
+	if !ok {
+		return false
+	}
+
+	return tp.VerifyWebhookSignature(channel.ChannelSecret, body, signature)
 }
 
 // # Income Request Handler Factory
 //
 // This function creates a handler for incoming requests.
-func (tpb *TaipeionBot) incomeRequestHandlerFactory() func(w http.ResponseWriter, r *http.Request) {
+// If `routeChannel` is not `anyChannel`, the payload destination must match it.
+func (tpb *TaipeionBot) incomeRequestHandlerFactory(routeChannel int) func(w http.ResponseWriter, r *http.Request) {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -137,29 +286,77 @@ func (tpb *TaipeionBot) incomeRequestHandlerFactory() func(w http.ResponseWriter
 			return
 		}
 
+		// Cross-check the destination with the channel of the route.
+		if routeChannel != anyChannel && payload.Destination != routeChannel {
+			log.Printf("[EvHandler] Error: Payload destination (%d) does not match route channel (%d) on %s. Check channel configuration.\n", payload.Destination, routeChannel, r.URL.Path)
+			tpb.Metrics.Inc("webhook_route_mismatch_total")
+			http.Error(w, "Destination does not match route.", http.StatusBadRequest)
+			return
+		}
+
+		// Verify the signature with the secret of the destination channel.
+		if !tpb.verifyWebhookSignature(payload.Destination, body, r.Header.Get(tpb.signatureHeader)) {
+			log.Printf("[EvHandler] Error: Signature mismatch for channel (%d) from %s. Rejecting.\n", payload.Destination, r.RemoteAddr)
+			tpb.Metrics.Inc("webhook_signature_rejected_total")
+			http.Error(w, "Invalid signature.", http.StatusUnauthorized)
+			return
+		}
+
+		// All events of the request share the request ID.
+		request_id := newRequestId()
+		log.Printf("[EvHandler] Request (%s) with %d events for channel (%d).\n", request_id, len(payload.Events), payload.Destination)
+
 		// Iterate over the events.
 		for _, event := range payload.Events {
 			// Create an internal event
 			internal_event := ChatbotWebhookEvent{
 				Destination:  payload.Destination,
+				RequestId:    request_id,
 				MessageEvent: event,
 			}
 
 			// Enqueue the event
-			tpb.enqueueWebhookIncomingEvent(internal_event)
+			if err := tpb.enqueueWebhookIncomingEvent(r.Context(), internal_event); err != nil {
+				log.Println("[EvHandler] Error: Unable to enqueue event:", err)
+				http.Error(w, "Unable to accept event.", http.StatusServiceUnavailable)
+				return
+			}
 		}
 	}
 }
 
-func (tpb *TaipeionBot) webhookEventListener() error {
+// # Webhook Event Listener
+//
+// Serve webhooks until error or context cancellation.
+// Upon cancellation, the server stops accepting new requests and waits for the active ones.
+func (tpb *TaipeionBot) webhookEventListener(ctx context.Context) error {
 
-	http.HandleFunc("/", tpb.incomeRequestHandlerFactory()) // Set the default handler.
+	mux, err := tpb.webhookServeMux() // Build the webhook routes.
+	if err != nil {
+		return err
+	}
 
 	// Start the server.
 	full_server_address := fmt.Sprintf("%s:%d", tpb.ServerAddress, tpb.ServerPort)
 	log.Println("[EvListener] Starting server at ", full_server_address)
 
-	return http.ListenAndServe(full_server_address, nil) // Serve until error.
+	server := &http.Server{Addr: full_server_address, Handler: mux}
+
+	serve_err := make(chan error, 1)
+	go func() {
+		serve_err <- server.ListenAndServe() // Serve until error.
+	}()
+
+	select {
+	case err := <-serve_err:
+		return err
+
+	case <-ctx.Done():
+		log.Println("[EvListener] Received cancel signal, stop accepting webhooks.")
+		shutdown_ctx, cancel := context.WithTimeout(context.Background(), tpb.shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdown_ctx)
+	}
 }
 
 // # The Main Event Processor Loop
@@ -169,133 +366,219 @@ func (tpb *TaipeionBot) webhookEventListener() error {
 func (tpb *TaipeionBot) EventProcessorLoop(ctx context.Context) error {
 	log.Println("[EvLoop] Starting event processor loop.")
 	for {
-		select {
-		case <-ctx.Done(): // Check if the context is cancelled.
+		queued, err := tpb.eventQueue.Dequeue(ctx) // Wait for incoming events.
+
+		if ctx.Err() != nil { // Check if the context is cancelled.
 			log.Println("[EvLoop] Context cancelled. Exiting event processor loop.")
 			return nil
+		}
+		if err != nil {
+			return err
+		}
 
-		case event := <-tpb.eventQueue: // Wait for incoming events.
-			log.Printf("[EvProcessor] Processing event: %#v\n", event)
-			for _, event_handler := range tpb.eventHandlers { // Iterate over the event handlers.
-				log.Printf("[EvProcessor] Processing event with handler: %#v\n", event_handler.Callback)
-				go tpb.eventProcessorInternalCallbackWrapper(ctx, event_handler, event) // Call the handler in a goroutine.
+		tpb.dispatchEvent(queued)
+	}
+}
+
+// # Event Dispatcher
+//
+// Launch every registered event handler on the event, each in its own goroutine.
+// Running handlers are tracked so that shutdown can wait for them.
+// The event is acknowledged to the queue once all handlers have returned.
+func (tpb *TaipeionBot) dispatchEvent(queued QueuedEvent) {
+	event := queued.Event
+	log.Printf("[EvProcessor] Processing event: %#v\n", event)
+
+	// Select the handlers applying to the event.
+	handlers := tpb.applyRateLimit(event, tpb.routeEvent(event))
+
+	remaining := int64(len(handlers))
+	if remaining == 0 {
+		log.Printf("[EvProcessor] No handler matches event (%s) on channel (%d).\n", event.Type, event.Destination)
+		tpb.ackEvent(queued.Seq)
+		return
+	}
+
+	for _, event_handler := range handlers { // Iterate over the event handlers.
+		log.Printf("[EvProcessor] Processing event with handler: %s\n", event_handler.Name)
+
+		tpb.runningHandlers.Add(1)
+		atomic.AddInt64(&tpb.runningCount, 1)
+		go func(event_handler eventHandlerEntry) { // Call the handler in a goroutine.
+			defer tpb.runningHandlers.Done()
+			defer atomic.AddInt64(&tpb.runningCount, -1)
+			tpb.runSerialized(event_handler, event) // Failures are reported and parked in the dead-letter store.
+
+			if atomic.AddInt64(&remaining, -1) == 0 { // The last handler of the event.
+				tpb.ackEvent(queued.Seq)
 			}
-		}
+		}(event_handler)
+	}
+}
+
+// Acknowledge a processed event to the queue.
+func (tpb *TaipeionBot) ackEvent(seq uint64) {
+	if err := tpb.eventQueue.Ack(seq); err != nil {
+		log.Printf("[EvProcessor] Error: Unable to acknowledge event (%d): %s\n", seq, err)
 	}
 }
 
 // # Event Processor Callback Wrapper
 //
-// Since we've simplified the callback to a single function, we can use this wrapper to handle the semaphore.
-// So there's no need to deal with the semaphore or context in the callback function.
+// Since we've simplified the callback to a single function, we can use this wrapper to handle the scheduling.
+// So there's no need to deal with the handler slots in the callback function.
+//
+// The callback receives a context derived from `ctx`, carrying the request ID, the logger and the handler deadline.
+// It is cancelled upon shutdown deadline, and so is the wait for a slot.
 //
 // The function is for internal use only.
 func (tpb *TaipeionBot) eventProcessorInternalCallbackWrapper(ctx context.Context, event_handler_entry eventHandlerEntry, event ChatbotWebhookEvent) error {
-	ctx = context.WithoutCancel(ctx)
-	if event_handler_entry.IsPriority {
-		return event_handler_entry.Callback(tpb, event) // Directly call the event handler.
-	} else {
-		tpb.eventSemaphore.Acquire(ctx, 1)              // Acquire the semaphore, wait until available.
-		err := event_handler_entry.Callback(tpb, event) // Call the event handler.
+	ctx = withHandlerContext(ctx, event.RequestId, event_handler_entry.Name)
+
+	// Wrap the callback, global middlewares first.
+	callback := chainMiddlewares(event_handler_entry.contextCallback(), event_handler_entry.Middlewares...)
+	callback = chainMiddlewares(callback, tpb.middlewares...)
 
-		tpb.eventSemaphore.Release(1) // Release the semaphore if callback is done.
+	// Wait for a slot of the priority class, telling the user the position if the entry asks for it.
+	notify := tpb.waitNotifyFunc(ctx, event_handler_entry, event)
+	release, err := tpb.scheduler.acquireWithUpdates(ctx, event_handler_entry.Priority, event.Destination, tpb.waitProgressInterval, notify)
+	if err != nil {
+		LoggerFromContext(ctx).Println("Abandoned while waiting for a slot:", err)
 		return err
 	}
+	defer release() // Release the slot if callback is done.
+
+	// The deadline counts from the start of the call.
+	timeout := event_handler_entry.Timeout
+	if timeout == 0 {
+		timeout = tpb.handlerTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	started := time.Now()
+	err = callback(ctx, tpb, event) // Call the event handler.
+	if err == nil {
+		tpb.latency.record(event_handler_entry.Name, time.Since(started))
+	}
+	return err
 }
 
 // # Webhook Event Registration
 //
 // Register a webhook event callback.
-// All registered callbacks will be called when an event is received.
+// All registered callbacks will be called when an event of the types they handle is received.
+// An invalid handler is not registered, and the error is returned by `Start`.
 func (tpb *TaipeionBot) RegisterWebhookEventCallback(ev_handler_entry eventHandlerEntry) {
+	if err := tpb.validateHandler(ev_handler_entry); err != nil {
+		log.Printf("[Init] Error: Invalid handler (%s): %s\n", ev_handler_entry.Name, err)
+		tpb.registrationErr = errors.Join(tpb.registrationErr, fmt.Errorf("handler %s: %w", ev_handler_entry.Name, err))
+		return
+	}
+
+	ev_handler_entry.index = len(tpb.eventHandlers)
 	tpb.eventHandlers = append(tpb.eventHandlers, ev_handler_entry)
 }
 
+// Check the settings of a handler against the configuration of the chatbot.
+func (tpb *TaipeionBot) validateHandler(entry eventHandlerEntry) error {
+	if !tpb.scheduler.hasClass(entry.Priority) {
+		return fmt.Errorf("unknown priority class: %q", entry.Priority)
+	}
+	if err := validateSerialization(entry.SerialKey, entry.InFlightPolicy); err != nil {
+		return err
+	}
+
+	// Explicit names identify handlers, e.g. in dead letters.
+	if entry.explicitName {
+		for _, registered := range tpb.eventHandlers {
+			if registered.explicitName && registered.Name == entry.Name {
+				return fmt.Errorf("duplicate handler name: %q", entry.Name)
+			}
+		}
+	}
+	return nil
+}
+
 // # Main loop
 //
 // The main loop of the chatbot.
+// The listener and the processor are supervised and restarted upon failure.
+// Once the context is cancelled or a subsystem is given up, the event queue is drained and the outbox flushed.
 func (tpb *TaipeionBot) mainLoop(ctx context.Context) error {
 
-	// Create a child context.
-	ctx_child, cancel := context.WithCancel(ctx)
-	defer cancel() // All child coroutines will be cancelled upon main loop termination.
-
-	// Create a channel for errors.
-	subroutine_err := make(chan error)
-
-	// Create a semaphore for concurrency control.
-	tpb.eventSemaphore = semaphore.NewWeighted(int64(tpb.maxConcurrent))
+	// Record when the shutdown begins, the drain deadline counts from there.
+	shutdown_at := make(chan time.Time, 1)
+	context.AfterFunc(ctx, func() { shutdown_at <- time.Now() })
 
 	// Start all child coroutines.
 	log.Println("[Daemon] Starting all child coroutines.")
+	err := tpb.supervisor.run(ctx) // Returns after all subsystems stopped.
 
-	// Start the event processor loop.
-	go func(ctx context.Context, err_chan chan error) {
-		select {
-		case <-ctx.Done(): // Check if the context is cancelled.
-			log.Println("[EvListener] Received cancel signal.")
-			return
-		default:
-			err := tpb.EventProcessorLoop(ctx_child) // Start the event processor loop.
-			if err != nil {                          // The subroutine has returned an error.
-				err_chan <- err
-			}
-		}
-
-	}(ctx_child, subroutine_err)
-
-	// Start the webhook event listener.
-	go func(ctx context.Context, err_chan chan error) {
-
-		select {
-		case <-ctx.Done(): // Check if the context is cancelled.
-			log.Println("[EvListener] Received cancel signal.")
-			return
-
-		default:
-			err := tpb.webhookEventListener()
-			if err != nil { // The subroutine has returned an error.
-				err_chan <- err
-			}
-		}
-	}(ctx_child, subroutine_err)
+	if err != nil {
+		log.Println("[Daemon] Giving up:", err)
+	} else {
+		log.Println("[Daemon] Received cancelling signal, shutting down.")
+	}
 
-	// Wait for signals.
+	started := time.Now()
 	select {
-
-	case <-ctx.Done(): // Check if the context is cancelled.
-		log.Println("[Daemon] Received cancelling signal, terminating all subroutines.")
-		return nil
-
+	case started = <-shutdown_at:
 	default:
-		// Wait for the first error to occur.
-		err := <-subroutine_err
-		if err != nil {
-			return err
-		}
 	}
-	return nil
+
+	tpb.drainEventQueue(started.Add(tpb.shutdownTimeout))
+	tpb.flushOutbox(started.Add(tpb.shutdownTimeout)) // Messages failed during the drain.
+	return err
 }
 
+// # Start the Chatbot
+//
+// Run the chatbot until SIGINT or SIGTERM is received, or a subsystem keeps failing.
 func (tpb *TaipeionBot) Start() error {
 
+	// Refuse to start with invalid handlers.
+	if tpb.registrationErr != nil {
+		return tpb.registrationErr
+	}
+
+	// Cancelled upon termination signals.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Create the event queue.
-	// NOTE: Consider design a queue flushing machanism.
-	tpb.eventQueue = make(chan ChatbotWebhookEvent, 100)
+	event_queue, err := NewEventQueue(tpb.eventQueueConfig)
+	if err != nil {
+		return err
+	}
+	tpb.eventQueue = event_queue
+	defer tpb.eventQueue.Close()
 
-	for {
-		// Create a new context.
-		ctx, cancel := context.WithCancel(context.Background())
-
-		// Start the main loop.
-		err := tpb.mainLoop(ctx)
-		if err != nil { // The main loop has returned an error.
-			log.Println("[Daemon] Main loop returned an error:", err)
-			cancel()
-		}
+	// Register the subsystems, each of them owns its resources and can be restarted independently.
+	tpb.supervisor = newSupervisor(tpb.maxRestarts, tpb.restartBackoff, tpb.restartBackoffMax, tpb.Metrics)
+	tpb.supervisor.add("listener", tpb.webhookEventListener)
+	tpb.supervisor.add("processor", tpb.EventProcessorLoop)
+	tpb.supervisor.add("outbox", tpb.outboxLoop)
 
-		continue // Restart the main loop.
+	if err := tpb.mainLoop(ctx); err != nil {
+		return err
 	}
+
+	log.Println("[Daemon] Chatbot stopped.")
+	return nil
+}
+
+// # Subsystem Status
+//
+// Get the status of the supervised subsystems.
+func (tpb *TaipeionBot) SubsystemStatuses() []SubsystemStatus {
+	if tpb.supervisor == nil { // Not started yet.
+		return nil
+	}
+	return tpb.supervisor.statuses()
 }
 
 // # New Chatbot Instance
@@ -311,21 +594,48 @@ func NewChatbotInstance(
 	apiPlatformClientToken string,
 	maxConcurrentEvent int) *TaipeionBot {
 
+	handlers_ctx, cancel_handlers := context.WithCancel(context.Background())
+	dead_letters, _ := openDeadLetterStore(DeadLetterConfig{})        // In memory, never fails.
+	outbox, _ := openOutboxStore(OutboxConfig{})                      // In memory, never fails.
+	serialization, _ := newSerializationConfig(SerializationConfig{}) // Defaults, never fails.
+	metrics := NewMetricsRegistry()
+
 	return &TaipeionBot{
-		Endpoint:      endpoint,
-		Channels:      channels,
-		ServerAddress: serverAddress,
-		ServerPort:    serverPort,
-		maxConcurrent: maxConcurrentEvent,
-		api_client:    api_platform.NewApiPlatformClient(apiPlatformEndpoint, apiPlatformClientId, apiPlatformClientToken),
+		Endpoint:        endpoint,
+		Channels:        channels,
+		ServerAddress:   serverAddress,
+		ServerPort:      serverPort,
+		routingMode:     RouteFanOut,
+		webhookPath:     defaultWebhookPath,
+		signatureHeader: tp.DefaultWebhookSignatureHeader,
+		shutdownTimeout: defaultShutdownTimeout,
+		dedup:           newEventDeduplicator(DedupConfig{}),
+		overflow:        overflowPolicy{Policy: OverflowBlock, Timeout: defaultOverflowTimeout, BusyMessage: defaultBusyMessage},
+		errorSinks:      []ErrorSink{LogErrorSink(), MetricsErrorSink()},
+		retry:           newRetryConfig(RetryConfig{}),
+		deadLetters:     dead_letters,
+		outbox:          outbox,
+		handlersCtx:     handlers_ctx,
+		cancelHandlers:  cancel_handlers,
+		httpClient:      &http.Client{},
+		Metrics:         metrics,
+		scheduler:       newPriorityScheduler(maxConcurrentEvent, nil, channels, metrics),
+		serialization:   serialization,
+		serialLanes:     newSerialLanes(),
+		latency:         newLatencyTracker(),
+		rateLimiter:     newRateLimiter(RateLimitConfig{}, channels),
+		outboundLimiter: newOutboundLimiter(OutboundRateLimitConfig{}, channels, metrics),
+		messageSplit:    newMessageSplitConfig(MessageSplitConfig{}),
+		credentials:     newCredentialManager(CredentialConfig{}, apiPlatformCredentialFetcher(apiPlatformEndpoint, apiPlatformClientId, apiPlatformClientToken), metrics),
 	}
 }
 
 // # New Chatbot Instance from Configuration
 //
 // Create a new chatbot instance from a configuration.
-func NewChatbotFromConfig(config ServerConfig) *TaipeionBot {
-	return NewChatbotInstance(
+// Invalid settings are returned as an error.
+func NewChatbotFromConfig(config ServerConfig) (*TaipeionBot, error) {
+	bot := NewChatbotInstance(
 		config.Endpoint,
 		config.Channels,
 		config.Address,
@@ -334,4 +644,79 @@ func NewChatbotFromConfig(config ServerConfig) *TaipeionBot {
 		config.ApiPlatformClientId,
 		config.ApiPlatformClientToken,
 		config.MaxConcurrentEvent)
+
+	bot.scheduler = newPriorityScheduler(config.MaxConcurrentEvent, config.PriorityClasses, config.Channels, bot.Metrics)
+	serialization, err := newSerializationConfig(config.Serialization)
+	if err != nil {
+		return nil, err
+	}
+	bot.serialization = serialization
+	bot.waitProgressInterval = config.WaitProgressInterval
+	bot.rateLimiter = newRateLimiter(config.RateLimit, config.Channels)
+	bot.outboundLimiter = newOutboundLimiter(config.OutboundRateLimit, config.Channels, bot.Metrics)
+	bot.messageSplit = newMessageSplitConfig(config.MessageSplit)
+	bot.credentials.config = newCredentialConfig(config.Credentials)
+
+	switch config.RoutingMode {
+	case "":
+	case RouteFanOut, RouteFirstMatch:
+		bot.routingMode = config.RoutingMode
+	default:
+		return nil, fmt.Errorf("unknown routing mode: %q", config.RoutingMode)
+	}
+
+	if config.WebhookPath != "" {
+		bot.webhookPath = config.WebhookPath
+	}
+	bot.metricsPath = config.MetricsPath
+	bot.adminPath = config.AdminPath
+	bot.adminToken = config.AdminToken
+
+	bot.dedup = newEventDeduplicator(config.Dedup)
+	bot.eventQueueConfig = config.EventQueue
+	overflow, err := newOverflowPolicy(config.EventQueue)
+	if err != nil {
+		return nil, err
+	}
+	bot.overflow = overflow
+
+	bot.handlerTimeout = config.HandlerTimeout
+
+	bot.retry = newRetryConfig(config.HandlerRetry)
+	dead_letters, err := openDeadLetterStore(config.DeadLetter)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the dead-letter store: %w", err)
+	}
+	bot.deadLetters = dead_letters
+	outbox, err := openOutboxStore(config.Outbox)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the outbox: %w", err)
+	}
+	bot.outbox = outbox
+
+	if config.ErrorReport.OperatorUser != "" {
+		bot.AddErrorSink(OperatorNotificationSink(config.ErrorReport.OperatorUser, config.ErrorReport.OperatorChannel, config.ErrorReport.OperatorInterval))
+	}
+	if config.ErrorReport.ReplyOnError {
+		bot.AddErrorSink(ErrorReplySink(config.ErrorReport.ReplyMessage))
+	}
+
+	bot.maxRestarts = config.MaxSubsystemRestarts
+	bot.restartBackoff = config.RestartBackoff
+	bot.restartBackoffMax = config.RestartBackoffMax
+
+	if config.ShutdownTimeout > 0 {
+		bot.shutdownTimeout = config.ShutdownTimeout
+	}
+
+	if config.SignatureHeader != "" {
+		bot.signatureHeader = config.SignatureHeader
+	}
+
+	bot.skipSignatureCheck = config.SkipSignatureCheck
+	if bot.skipSignatureCheck {
+		log.Println("[Init] Warning: Webhook signature check is disabled.")
+	}
+
+	return bot, nil
 }
@@ -2,12 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
-	"sync/atomic"
+
+	tp "taipeion/core"
 )
 
 // # LLM User Query Struct
@@ -33,7 +35,6 @@ type LlmModelResponse struct {
 type LlmConnector struct {
 	ChannelMap     ChannelIdConfigMap // A map from channel ID to channel configuration.
 	LocalDebugMode bool               // Indicates if the LLM connector is in local debug mode.
-	waitingCounter int32              // Indicates the current waiting requests.
 }
 
 // # New LLM Connector
@@ -46,54 +47,51 @@ func NewLlmConnector(channelMap ChannelIdConfigMap, LocalDebugMode bool) *LlmCon
 	}
 }
 
-func (c *LlmConnector) LlmCallback(bot *TaipeionBot, event ChatbotWebhookEvent) error {
-
-	// Check if incoming event is text message.
-	// We do not support non-text messages for now, we may implement it later.
-	if event.Message.Type != "text" {
-		log.Println("[PrivateMessageCallback] Received non-text message. Ignoring.")
-		return nil
-	}
+// # LLM Event Matcher
+//
+// The events handled by the LLM callback: text messages starting with the trigger word,
+// on a channel configured for the LLM.
+// Register the callback with it, the callback itself does not filter events.
+func (c *LlmConnector) Matcher() EventMatcher {
+	return MatchAll(
+		MatchMessageTypes(tp.MessageTypeText),
+		func(bot *TaipeionBot, event ChatbotWebhookEvent) bool {
+			channel, ok := c.ChannelMap[event.Destination]
+			return ok && MatchPrefix(channel.ChannelTriggerPrefix)(bot, event)
+		},
+	)
+}
 
-	// Check if channel ID is in the channel map.
-	if _, ok := c.ChannelMap[event.Destination]; !ok {
-		log.Printf("[LlmCallback] Channel ID (%d) not found in config. Ignoring.\n", event.Destination)
-		return nil
-	}
+// # LLM Callback
+//
+// Send the user query to the LLM server and reply with the model response.
+// The request to the LLM server and the replies are aborted when the context is done.
+func (c *LlmConnector) LlmCallback(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent) error {
 
 	// Information gathering.
-	chan_id := event.Destination                               // Channel ID
-	userId := event.Source.UserId                              // User ID
-	userQuery := event.Message.Text                            // User query
-	trigger_word := c.ChannelMap[chan_id].ChannelTriggerPrefix // Trigger word
+	chan_id := event.Destination    // Channel ID
+	userId := event.Source.UserId   // User ID
+	userQuery := event.Message.Text // User query
 
-	log.Printf("[LlmCallback] Received user (%s) query on channel (%d): %s\n", userId, chan_id, userQuery)
+	logger := LoggerFromContext(ctx)
+	logger.Printf("[LlmCallback] Received user (%s) query on channel (%d): %s\n", userId, chan_id, userQuery)
 
-	// Check if the user query starts with the trigger word.
-	if !strings.HasPrefix(strings.TrimSpace(userQuery), trigger_word) {
-		log.Printf("[LlmCallback] User query does not start with trigger word (%s). Ignoring.\n", trigger_word)
-		return nil
-	}
 	// Check debug mode.
 	if c.LocalDebugMode {
 		log.Println("[LlmCallback] Local debug mode is enabled.")
-		log.Printf("[LlmCallback] [Debug info] User ID: %s, Channel ID: %d, User Query: %s Trigger Word: %s\n", userId, chan_id, userQuery, trigger_word)
+		log.Printf("[LlmCallback] [Debug info] User ID: %s, Channel ID: %d, User Query: %s\n", userId, chan_id, userQuery)
 		log.Println("[LlmCallback] The following procedure is sending the user query to the LLM server in normal mode.")
 		log.Println("[LlmCallback] LLM Callback will now exit.")
 
 		return nil
 	}
-	// Send a friendly message.
-	err := bot.SendPrivateMessage(userId, fmt.Sprintf("正在處理您的問題，視當前情況大約需要30秒~數分鐘不等\n感謝您的耐心等待!\n(目前排隊: %d)", c.waitingCounter), chan_id)
+	// Send a friendly message, the queue position is sent by `WaitNotifier` while waiting.
+	err := bot.SendPrivateMessageContext(ctx, userId, "正在處理您的問題，視當前情況大約需要30秒~數分鐘不等\n感謝您的耐心等待!", chan_id)
 
 	if err != nil {
 		return err
 	}
 
-	// Counter control.
-	atomic.AddInt32(&c.waitingCounter, 1)        // Add waiting counter by 1.
-	defer atomic.AddInt32(&c.waitingCounter, -1) // Decrease waiting counter by 1 while exiting.
-
 	// Create a new user query.
 	userQueryPayload := LlmUserQuery{
 		ChannelId: chan_id,
@@ -102,9 +100,9 @@ func (c *LlmConnector) LlmCallback(bot *TaipeionBot, event ChatbotWebhookEvent)
 	}
 
 	// Send the user query to the LLM server.
-	response, err := c.LlmRequestSender(userQueryPayload)
+	response, err := c.LlmRequestSender(ctx, userQueryPayload)
 	if err != nil {
-		log.Println("[LlmCallback] Unable to send user query to LLM server:", err)
+		logger.Println("[LlmCallback] Unable to send user query to LLM server:", err)
 		return err
 	}
 
@@ -117,15 +115,39 @@ func (c *LlmConnector) LlmCallback(bot *TaipeionBot, event ChatbotWebhookEvent)
 		concatedResponse = strings.ReplaceAll(concatedResponse, char, " ")
 	}
 
-	log.Printf("[LlmCallback] Model response for user (%s) on channel (%d): %s\n", userId, chan_id, concatedResponse)
+	logger.Printf("[LlmCallback] Model response for user (%s) on channel (%d): %s\n", userId, chan_id, concatedResponse)
+
+	return bot.SendPrivateMessageContext(ctx, userId, concatedResponse, chan_id) // Send final result.
+}
 
-	return bot.SendPrivateMessage(userId, concatedResponse, chan_id) // Send final result.
+// # LLM Wait Notifier
+//
+// Tell the user the queue position and the estimated wait while the query waits for a slot.
+// Register it with `WithWaitNotifier` on the entry of the LLM callback.
+func (c *LlmConnector) WaitNotifier(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent, status WaitStatus) {
+	if c.LocalDebugMode {
+		log.Printf("[LlmCallback] [Debug info] User (%s) is waiting at position %d, ETA %s.\n", event.Source.UserId, status.Position, status.Eta)
+		return
+	}
+
+	message := fmt.Sprintf("已收到您的問題，目前排隊第 %d 位", status.Position)
+	if status.Update {
+		message = fmt.Sprintf("您的問題仍在排隊中，目前排隊第 %d 位", status.Position)
+	}
+	if status.Eta > 0 {
+		message += fmt.Sprintf("，預估等待%s", formatWaitDuration(status.Eta))
+	}
+	message += "。\n感謝您的耐心等待!"
+
+	if err := bot.SendPrivateMessageContext(ctx, event.Source.UserId, message, event.Destination); err != nil {
+		LoggerFromContext(ctx).Println("[LlmCallback] Unable to send queue position:", err)
+	}
 }
 
 // # LLM Request Sender
 //
 // This function sends a user query to the LLM server and returns the response.
-func (c *LlmConnector) LlmRequestSender(prompt LlmUserQuery) (LlmModelResponse, error) {
+func (c *LlmConnector) LlmRequestSender(ctx context.Context, prompt LlmUserQuery) (LlmModelResponse, error) {
 
 	// Serialize the user query.
 	request_payload, err := json.Marshal(prompt)
@@ -135,7 +157,8 @@ func (c *LlmConnector) LlmRequestSender(prompt LlmUserQuery) (LlmModelResponse,
 	}
 
 	// Create a new HTTP request.
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"POST",
 		c.ChannelMap[prompt.ChannelId].ChannelLlmEndpoint,
 		bytes.NewBuffer(request_payload))
@@ -0,0 +1,12 @@
+package main
+
+import (
+	tp "taipeion/core"
+)
+
+// # Typed Content of the Event Message
+//
+// Shortcut of `event.Message.Content()`, use a type switch on the result to handle each message type.
+func (event ChatbotWebhookEvent) Content() tp.InboundContent {
+	return event.Message.Content()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+
+	tp "taipeion/core"
+)
+
+// # Message Split Configuration
+//
+// Text messages longer than the maximum length are sent in several parts, cut at paragraph
+// or sentence boundaries, see `tp.SplitText`.
+type MessageSplitConfig struct {
+	MaxLength int  `yaml:"max-length"` // Maximum characters of a text message, defaults to `tp.MaxTextLength`.
+	Numbering bool `yaml:"numbering"`  // Append " (1/3)" to the parts.
+}
+
+// Fill zero values of the message split configuration with defaults.
+func newMessageSplitConfig(config MessageSplitConfig) MessageSplitConfig {
+	if config.MaxLength <= 0 {
+		config.MaxLength = tp.MaxTextLength
+	}
+	return config
+}
+
+// # Send Split Text
+//
+// Send the text as one or more text messages with the given ask and recipient, in order.
+// Each part is sent once the previous one is delivered, also when it is retried through the outbox.
+// Stops at the first part failing for good.
+func (tpb *TaipeionBot) sendSplitText(ctx context.Context, payload tp.ChannelMessagePayload, text string, target_channel int) error {
+	parts := []string{text}
+	if tpb.messageSplit.Numbering {
+		parts = tp.SplitTextNumbered(text, tpb.messageSplit.MaxLength)
+	} else if len(text) > tpb.messageSplit.MaxLength { // Bytes are never fewer than characters.
+		parts = tp.SplitText(text, tpb.messageSplit.MaxLength)
+	}
+	if len(parts) == 0 {
+		parts = []string{text} // Blank, rejected by the validation.
+	} else if len(parts) > 1 {
+		tpb.Metrics.Inc("split_messages_total")
+	}
+
+	after := ""
+	for _, part := range parts {
+		payload.Message = tp.NewTextMessage(part)
+		if err := payload.Message.Validate(); err != nil {
+			return err
+		}
+
+		id, err := tpb.sendThroughOutbox(ctx, payload, target_channel, after)
+		if err != nil {
+			return err
+		}
+		after = id
+	}
+	return nil
+}
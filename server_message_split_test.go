@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	tp "taipeion/core"
+)
+
+func TestSplitTextSentInOrder(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	failed := false
+	bot := outboxHandlerTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !failed { // The first part is retried through the outbox.
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload tp.ChannelMessagePayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		received = append(received, payload.Message.Text)
+	})
+	bot.messageSplit = MessageSplitConfig{MaxLength: 16, Numbering: true}
+
+	if err := bot.SendPrivateMessage("citizen", "第一段回答。\n\n第二段回答。\n\n第三段回答。", 1); err != nil {
+		t.Fatalf("Expected the message to be sent, got %s", err)
+	}
+	if len(bot.OutboxMessages(OutboxPending)) != 3 {
+		t.Fatalf("Expected every part to wait for the first one, got %#v", bot.OutboxMessages(""))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bot.outboxLoop(ctx)
+
+	deadline := time.Now().Add(time.Second)
+	for len(bot.OutboxMessages(OutboxDelivered)) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected every part to be delivered, got %#v", bot.OutboxMessages(""))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"第一段回答。 (1/3)", "第二段回答。 (2/3)", "第三段回答。 (3/3)"}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected the parts in order, got %q", received)
+	}
+}
+
+func TestSplitTextFailsAfterFailedPart(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	requests := 0
+	bot := outboxHandlerTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 { // The first part is retried through the outbox, then rejected.
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if requests == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var payload tp.ChannelMessagePayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		received = append(received, payload.Message.Text)
+	})
+	bot.messageSplit = MessageSplitConfig{MaxLength: 16, Numbering: true}
+
+	if err := bot.SendPrivateMessage("citizen", "第一段回答。\n\n第二段回答。\n\n第三段回答。", 1); err != nil {
+		t.Fatalf("Expected the message to be sent, got %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bot.outboxLoop(ctx)
+
+	deadline := time.Now().Add(time.Second)
+	for len(bot.OutboxMessages(OutboxFailed)) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected every part to fail, got %#v", bot.OutboxMessages(""))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 2 || len(received) != 0 {
+		t.Errorf("Expected the parts after the failed one not to be sent, got %d requests, %q", requests, received)
+	}
+	if count := bot.Metrics.Get("outbox_failed_total"); count != 3 {
+		t.Errorf("Expected 3 failed messages, got %d", count)
+	}
+}
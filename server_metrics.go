@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// # Metrics Registry
+//
+// A minimal in-process registry of named counters and gauges.
+// Values are identified by name and created on first use.
+type MetricsRegistry struct {
+	mu     sync.RWMutex
+	values map[string]*int64
+}
+
+// # New Metrics Registry
+//
+// Create an empty metrics registry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		values: make(map[string]*int64),
+	}
+}
+
+// Get the storage of a metric, create it if not exists.
+func (m *MetricsRegistry) value(name string) *int64 {
+	m.mu.RLock()
+	v, ok := m.values[name]
+	m.mu.RUnlock()
+	if ok {
+		return v
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, ok = m.values[name]; !ok {
+		v = new(int64)
+		m.values[name] = v
+	}
+	return v
+}
+
+// Add delta to a counter.
+func (m *MetricsRegistry) Add(name string, delta int64) {
+	atomic.AddInt64(m.value(name), delta)
+}
+
+// Increase a counter by one.
+func (m *MetricsRegistry) Inc(name string) {
+	m.Add(name, 1)
+}
+
+// Set a gauge to the given value.
+func (m *MetricsRegistry) Set(name string, v int64) {
+	atomic.StoreInt64(m.value(name), v)
+}
+
+// Get the current value of a metric.
+func (m *MetricsRegistry) Get(name string) int64 {
+	return atomic.LoadInt64(m.value(name))
+}
+
+// Take a snapshot of all metrics.
+func (m *MetricsRegistry) Snapshot() map[string]int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snapshot := make(map[string]int64, len(m.values))
+	for name, v := range m.values {
+		snapshot[name] = atomic.LoadInt64(v)
+	}
+	return snapshot
+}
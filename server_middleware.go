@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"slices"
+	"time"
+)
+
+// # Middleware
+//
+// A middleware wraps a callback with cross-cutting behaviour.
+// It may act before and after calling `next`, or decide not to call it at all.
+// Callbacks without context are adapted before being wrapped.
+type Middleware func(next ContextEventCallback) ContextEventCallback
+
+var (
+	ErrHandlerPanic   = errors.New("event handler panicked")
+	ErrHandlerTimeout = errors.New("event handler timed out")
+)
+
+// # Chain Middlewares
+//
+// Wrap the callback with the middlewares, the first middleware is the outermost one.
+func chainMiddlewares(callback ContextEventCallback, middlewares ...Middleware) ContextEventCallback {
+	for _, middleware := range slices.Backward(middlewares) {
+		callback = middleware(callback)
+	}
+	return callback
+}
+
+// # Global Middleware Registration
+//
+// Add middlewares applied to every handler, outside of the handler's own middlewares.
+func (tpb *TaipeionBot) Use(middlewares ...Middleware) {
+	tpb.middlewares = append(tpb.middlewares, middlewares...)
+}
+
+// # Recover Middleware
+//
+// Turn a panic of the callback into a `*PanicError`, so that inner middlewares see it as an error.
+// Panics are recovered by the processor anyway, this only matters for the order of middlewares.
+func RecoverMiddleware() Middleware {
+	return func(next ContextEventCallback) ContextEventCallback {
+		return func(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent) (err error) {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					err = &PanicError{Value: recovered, Stack: debug.Stack()}
+				}
+			}()
+			return next(ctx, bot, event)
+		}
+	}
+}
+
+// # Timeout Middleware
+//
+// Cancel the context of the callback after the timeout, and return `ErrHandlerTimeout`
+// without waiting for callbacks ignoring the cancellation.
+func TimeoutMiddleware(timeout time.Duration) Middleware {
+	return func(next ContextEventCallback) ContextEventCallback {
+		return func(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			result := make(chan error, 1)
+			go func() {
+				defer func() { // The goroutine is out of reach of the processor's recovery.
+					if recovered := recover(); recovered != nil {
+						result <- &PanicError{Value: recovered, Stack: debug.Stack()}
+					}
+				}()
+				result <- next(ctx, bot, event)
+			}()
+
+			select {
+			case err := <-result:
+				return err
+			case <-ctx.Done():
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					return fmt.Errorf("%w after %s", ErrHandlerTimeout, timeout)
+				}
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// # Logging Middleware
+//
+// Log the start, the duration and the result of every call.
+func LoggingMiddleware() Middleware {
+	return func(next ContextEventCallback) ContextEventCallback {
+		return func(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent) error {
+			logger := LoggerFromContext(ctx)
+			logger.Printf("Start, event (%s) from user (%s) on channel (%d).\n", event.Type, event.Source.UserId, event.Destination)
+			started := time.Now()
+
+			err := next(ctx, bot, event)
+
+			if err != nil {
+				logger.Printf("Failed after %s: %s\n", time.Since(started).Round(time.Millisecond), err)
+			} else {
+				logger.Printf("Done in %s.\n", time.Since(started).Round(time.Millisecond))
+			}
+			return err
+		}
+	}
+}
+
+// # Metrics Middleware
+//
+// Count calls, errors and the total duration of every handler.
+func MetricsMiddleware() Middleware {
+	return func(next ContextEventCallback) ContextEventCallback {
+		return func(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent) error {
+			name := HandlerNameFromContext(ctx)
+			started := time.Now()
+			err := next(ctx, bot, event)
+
+			bot.Metrics.Inc(fmt.Sprintf("handler_%s_calls_total", name))
+			bot.Metrics.Add(fmt.Sprintf("handler_%s_duration_ms_total", name), time.Since(started).Milliseconds())
+			if err != nil {
+				bot.Metrics.Inc(fmt.Sprintf("handler_%s_errors_total", name))
+			}
+			return err
+		}
+	}
+}
+
+// # Blocklist Middleware
+//
+// Skip events from the blocked users.
+func BlocklistMiddleware(userIds ...string) Middleware {
+	blocked := make(map[string]struct{}, len(userIds))
+	for _, id := range userIds {
+		blocked[id] = struct{}{}
+	}
+
+	return func(next ContextEventCallback) ContextEventCallback {
+		return func(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent) error {
+			if _, ok := blocked[event.Source.UserId]; ok {
+				log.Printf("[Middleware] Ignoring event from blocked user (%s).\n", event.Source.UserId)
+				bot.Metrics.Inc("blocked_user_events_total")
+				return nil
+			}
+			return next(ctx, bot, event)
+		}
+	}
+}
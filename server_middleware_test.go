@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	tracing := func(name string) Middleware {
+		return func(next ContextEventCallback) ContextEventCallback {
+			return func(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent) error {
+				calls = append(calls, name)
+				return next(ctx, bot, event)
+			}
+		}
+	}
+
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	bot.Use(tracing("global"))
+
+	entry := ScheduleCallbackHighestPriority(func(bot *TaipeionBot, event ChatbotWebhookEvent) error {
+		calls = append(calls, "callback")
+		return nil
+	}).With(tracing("first"), tracing("second"))
+
+	bot.eventProcessorInternalCallbackWrapper(bot.handlersCtx, entry, textEvent(1, "hello"))
+
+	if expected := []string{"global", "first", "second", "callback"}; !slices.Equal(calls, expected) {
+		t.Errorf("Expected %v, got %v", expected, calls)
+	}
+}
+
+func TestBuiltinMiddlewares(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	event := textEvent(1, "hello")
+	event.Source.UserId = "spammer"
+	ctx := withHandlerContext(context.Background(), "request", "test")
+
+	panicking := AdaptCallback(func(bot *TaipeionBot, event ChatbotWebhookEvent) error { panic("boom") })
+	if err := RecoverMiddleware()(panicking)(ctx, bot, event); !errors.Is(err, ErrHandlerPanic) {
+		t.Errorf("Expected ErrHandlerPanic, got %v", err)
+	}
+
+	cancelled := make(chan struct{})
+	slow := func(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	}
+	if err := TimeoutMiddleware(10*time.Millisecond)(slow)(ctx, bot, event); !errors.Is(err, ErrHandlerTimeout) {
+		t.Errorf("Expected ErrHandlerTimeout, got %v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the callback context to be cancelled")
+	}
+
+	called := false
+	callback := AdaptCallback(func(bot *TaipeionBot, event ChatbotWebhookEvent) error { called = true; return nil })
+	BlocklistMiddleware("spammer")(callback)(ctx, bot, event)
+	if called {
+		t.Errorf("Expected callback not to be called for blocked user")
+	}
+
+	MetricsMiddleware()(callback)(ctx, bot, event)
+	if bot.Metrics.Get("handler_test_calls_total") != 1 {
+		t.Errorf("Expected call to be counted")
+	}
+}
+
+func TestHandlerDeadline(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+
+	var requestId string
+	var deadlineSet bool
+	entry := ScheduleContextCallbackNormalPriority(func(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent) error {
+		requestId = RequestIdFromContext(ctx)
+		_, deadlineSet = ctx.Deadline()
+		<-ctx.Done()
+		return ctx.Err()
+	}).WithTimeout(10 * time.Millisecond)
+
+	event := textEvent(1, "hello")
+	event.RequestId = "abc"
+	if err := bot.eventProcessorInternalCallbackWrapper(bot.handlersCtx, entry, event); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if requestId != "abc" || !deadlineSet {
+		t.Errorf("Expected request ID and deadline in context, got %q, %v", requestId, deadlineSet)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// # Outbound Rate Limit Configuration
+//
+// Rate of the messages sent to TaipeiON across all channels, see also the per-channel
+// `outbound-per-second` and `outbound-burst`.
+type OutboundRateLimitConfig struct {
+	PerSecond float64 `yaml:"per-second"` // Messages per second, unlimited if zero.
+	Burst     int     `yaml:"burst"`      // Messages sent at once after an idle period, defaults to 1.
+}
+
+// A token bucket, refilled continuously.
+type outboundBucket struct {
+	rate    float64 // Tokens per second.
+	burst   float64
+	tokens  float64
+	updated time.Time
+}
+
+// Create a bucket, nil if unlimited.
+func newOutboundBucket(per_second float64, burst int, now time.Time) *outboundBucket {
+	if per_second <= 0 {
+		return nil
+	}
+	burst = max(burst, 1)
+	return &outboundBucket{rate: per_second, burst: float64(burst), tokens: float64(burst), updated: now}
+}
+
+func (b *outboundBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.updated).Seconds()*b.rate)
+	b.updated = now
+}
+
+// Whether a token is available, a nil bucket always has one.
+func (b *outboundBucket) ready(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.refill(now)
+	return b.tokens >= 1
+}
+
+func (b *outboundBucket) take() {
+	if b != nil {
+		b.tokens--
+	}
+}
+
+// Time when the next token is available.
+func (b *outboundBucket) readyAt(now time.Time) time.Time {
+	if b == nil {
+		return now
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		return now
+	}
+	return now.Add(time.Duration((1 - b.tokens) / b.rate * float64(time.Second)))
+}
+
+type outboundWaiter struct {
+	ready   chan struct{} // Closed once the send is allowed.
+	granted bool
+}
+
+// The bucket and the waiting sends of a channel.
+type outboundChannel struct {
+	bucket  *outboundBucket
+	waiting *list.List // Waiters in arrival order.
+}
+
+// # Outbound Rate Limiter
+//
+// Limit the messages sent to TaipeiON per channel and globally.
+// Sends of the same channel wait in arrival order, and the channels with waiting sends take
+// turns for the global tokens, so that a broadcast does not hold back the replies of other channels.
+type outboundLimiter struct {
+	mu       sync.Mutex
+	global   *outboundBucket // Nil if unlimited.
+	channels map[int]*outboundChannel
+	limits   map[int]Channel
+	turns    []*outboundChannel // Channels with waiting sends, the next to serve first.
+	timer    *time.Timer        // Dispatches the waiters once tokens are available.
+	metrics  *MetricsRegistry
+}
+
+func newOutboundLimiter(config OutboundRateLimitConfig, channels map[int]Channel, metrics *MetricsRegistry) *outboundLimiter {
+	return &outboundLimiter{
+		global:   newOutboundBucket(config.PerSecond, config.Burst, time.Now()),
+		channels: make(map[int]*outboundChannel),
+		limits:   channels,
+		metrics:  metrics,
+	}
+}
+
+// Get the state of the channel, created if needed.
+func (l *outboundLimiter) channel(id int, now time.Time) *outboundChannel {
+	channel, ok := l.channels[id]
+	if !ok {
+		config := l.limits[id]
+		channel = &outboundChannel{bucket: newOutboundBucket(config.OutboundPerSecond, config.OutboundBurst, now), waiting: list.New()}
+		l.channels[id] = channel
+	}
+	return channel
+}
+
+// # Wait for Outbound Slot
+//
+// Block until a message may be sent to the channel, or the context is done.
+// The time spent waiting is counted in `outbound_throttled_ms_total`.
+func (l *outboundLimiter) wait(ctx context.Context, channel_id int) error {
+	l.mu.Lock()
+	now := time.Now()
+	channel := l.channel(channel_id, now)
+
+	// Send right away if nobody is waiting for the tokens.
+	if channel.waiting.Len() == 0 && len(l.turns) == 0 && channel.bucket.ready(now) && l.global.ready(now) {
+		channel.bucket.take()
+		l.global.take()
+		l.mu.Unlock()
+		return nil
+	}
+
+	waiter := &outboundWaiter{ready: make(chan struct{})}
+	element := channel.waiting.PushBack(waiter)
+	if channel.waiting.Len() == 1 {
+		l.turns = append(l.turns, channel)
+	}
+	l.dispatchLocked(now)
+	granted := waiter.granted
+	l.mu.Unlock()
+	if granted {
+		return nil // Only other channels were waiting.
+	}
+
+	started := time.Now()
+	defer func() {
+		waited := time.Since(started).Milliseconds()
+		l.metrics.Inc("outbound_throttled_total")
+		l.metrics.Add("outbound_throttled_ms_total", waited)
+		l.metrics.Add(fmt.Sprintf("outbound_channel_%d_throttled_ms_total", channel_id), waited)
+	}()
+
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if waiter.granted {
+			return nil // Granted meanwhile, the tokens are taken anyway.
+		}
+		channel.waiting.Remove(element)
+		if channel.waiting.Len() == 0 {
+			l.turns = slices.DeleteFunc(l.turns, func(c *outboundChannel) bool { return c == channel })
+		}
+		return ctx.Err()
+	}
+}
+
+// Grant the tokens to the waiters, the channels taking turns, and schedule the next dispatch
+// once the next token is available.
+func (l *outboundLimiter) dispatchLocked(now time.Time) {
+	for len(l.turns) > 0 && l.global.ready(now) {
+		// The first channel in turn with a token available.
+		index := slices.IndexFunc(l.turns, func(c *outboundChannel) bool { return c.bucket.ready(now) })
+		if index < 0 {
+			break
+		}
+		channel := l.turns[index]
+		channel.bucket.take()
+		l.global.take()
+
+		waiter := channel.waiting.Remove(channel.waiting.Front()).(*outboundWaiter)
+		waiter.granted = true
+		close(waiter.ready)
+
+		// The channel goes to the back of the turns, or leaves them if nobody else waits.
+		l.turns = slices.Delete(l.turns, index, index+1)
+		if channel.waiting.Len() > 0 {
+			l.turns = append(l.turns, channel)
+		}
+	}
+
+	if len(l.turns) == 0 {
+		return
+	}
+
+	// Earliest time a channel in turn and the global bucket have a token.
+	next := time.Time{}
+	for _, channel := range l.turns {
+		if at := channel.bucket.readyAt(now); next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	if at := l.global.readyAt(now); at.After(next) {
+		next = at
+	}
+
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.timer = time.AfterFunc(max(next.Sub(now), time.Millisecond), func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.dispatchLocked(time.Now())
+	})
+}
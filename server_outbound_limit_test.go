@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOutboundLimiterChannelRate(t *testing.T) {
+	metrics := NewMetricsRegistry()
+	limiter := newOutboundLimiter(OutboundRateLimitConfig{}, map[int]Channel{1: {OutboundPerSecond: 20, OutboundBurst: 2}}, metrics)
+
+	started := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := limiter.wait(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The burst goes out at once, then one message every 50ms.
+	if elapsed := time.Since(started); elapsed < 140*time.Millisecond {
+		t.Errorf("Expected the sends to be throttled, took %s", elapsed)
+	}
+	if metrics.Get("outbound_throttled_total") != 3 || metrics.Get("outbound_channel_1_throttled_ms_total") == 0 {
+		t.Errorf("Expected the throttled time to be counted, got %v", metrics.Snapshot())
+	}
+
+	// Other channels are not limited.
+	started = time.Now()
+	for i := 0; i < 5; i++ {
+		limiter.wait(context.Background(), 2)
+	}
+	if elapsed := time.Since(started); elapsed > 20*time.Millisecond {
+		t.Errorf("Expected the sends of an unlimited channel to go out at once, took %s", elapsed)
+	}
+}
+
+func TestOutboundLimiterFairness(t *testing.T) {
+	limiter := newOutboundLimiter(OutboundRateLimitConfig{PerSecond: 50, Burst: 1}, nil, NewMetricsRegistry())
+	limiter.wait(context.Background(), 1) // Empty the bucket.
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	send := func(channel int) {
+		defer wg.Done()
+		if err := limiter.wait(context.Background(), channel); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		order = append(order, channel)
+		mu.Unlock()
+	}
+
+	// A broadcast on channel 1, then a reply on channel 2.
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go send(1)
+	}
+	time.Sleep(5 * time.Millisecond)
+	wg.Add(1)
+	go send(2)
+	wg.Wait()
+
+	for i, channel := range order {
+		if channel == 2 && i > 2 {
+			t.Errorf("Expected channel 2 to take its turn, got order %v", order)
+		}
+	}
+}
+
+func TestOutboundLimiterCancel(t *testing.T) {
+	limiter := newOutboundLimiter(OutboundRateLimitConfig{PerSecond: 10, Burst: 1}, nil, NewMetricsRegistry())
+	limiter.wait(context.Background(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.wait(ctx, 1); err != context.DeadlineExceeded {
+		t.Errorf("Expected the wait to be aborted, got %v", err)
+	}
+
+	// The cancelled send does not take a token.
+	started := time.Now()
+	limiter.wait(context.Background(), 1)
+	if elapsed := time.Since(started); elapsed > 150*time.Millisecond {
+		t.Errorf("Expected the next send after one interval, took %s", elapsed)
+	}
+}
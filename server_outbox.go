@@ -0,0 +1,501 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	tp "taipeion/core"
+)
+
+// Delivery status of an outbound message.
+const (
+	OutboxPending   = "pending"   // Waiting for the next attempt.
+	OutboxDelivered = "delivered" // Accepted by TaipeiON.
+	OutboxFailed    = "failed"    // Rejected for good, or out of attempts.
+)
+
+const (
+	defaultOutboxMaxAttempts = 8
+	defaultOutboxBackoff     = 2 * time.Second
+	defaultOutboxBackoffMax  = 10 * time.Minute
+	defaultOutboxMaxEntries  = 1000
+)
+
+var ErrOutboxMessageNotFound = errors.New("outbox message not found")
+
+// # Outbox Configuration
+type OutboxConfig struct {
+	Disabled    bool          `yaml:"disabled"`     // Send messages once, failures are returned to the caller.
+	Path        string        `yaml:"path"`         // Directory of the outbox, kept in memory if empty.
+	MaxAttempts int           `yaml:"max-attempts"` // Attempts of a message, including the first one.
+	Backoff     time.Duration `yaml:"backoff"`      // Wait before the first retry, doubled on each attempt and jittered.
+	BackoffMax  time.Duration `yaml:"backoff-max"`  // Upper bound of the wait between attempts.
+	MaxEntries  int           `yaml:"max-entries"`  // Messages kept, the oldest delivered or failed ones are evicted.
+}
+
+// # Outbox Message
+//
+// An outbound message and its delivery status.
+type OutboxMessage struct {
+	Id            string                   `json:"id"`
+	Channel       int                      `json:"channel"`
+	Payload       tp.ChannelMessagePayload `json:"payload"`
+	Status        string                   `json:"status"`          // One of `OutboxPending`, `OutboxDelivered` or `OutboxFailed`.
+	After         string                   `json:"after,omitempty"` // ID of the message sent before this one.
+	Attempts      int                      `json:"attempts"`        // Attempts so far.
+	Error         string                   `json:"error,omitempty"`
+	CreatedAt     time.Time                `json:"created_at"`
+	UpdatedAt     time.Time                `json:"updated_at"`
+	NextAttemptAt time.Time                `json:"next_attempt_at"` // Time of the next attempt of a pending message.
+}
+
+// # Outbox Store
+//
+// Keep the outbound messages in memory, and as one JSON file per message if a directory is set,
+// so that pending messages are sent after a restart.
+type outboxStore struct {
+	mu       sync.Mutex
+	config   OutboxConfig
+	messages map[string]OutboxMessage
+	claimed  map[string]struct{} // Messages being sent.
+	wake     chan struct{}       // Wakes the outbox loop up when a message is queued.
+}
+
+// # Open Outbox Store
+//
+// Create the store from configuration and load the messages from the directory.
+// Returns nil if disabled.
+func openOutboxStore(config OutboxConfig) (*outboxStore, error) {
+	if config.Disabled {
+		return nil, nil
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultOutboxMaxAttempts
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaultOutboxBackoff
+	}
+	if config.BackoffMax <= 0 {
+		config.BackoffMax = defaultOutboxBackoffMax
+	}
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = defaultOutboxMaxEntries
+	}
+
+	store := &outboxStore{
+		config:   config,
+		messages: make(map[string]OutboxMessage),
+		claimed:  make(map[string]struct{}),
+		wake:     make(chan struct{}, 1),
+	}
+	if config.Path == "" {
+		return store, nil
+	}
+
+	if err := os.MkdirAll(config.Path, 0o755); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(config.Path, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	pending := 0
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var message OutboxMessage
+		if err := json.Unmarshal(content, &message); err != nil {
+			log.Printf("[Outbox] Error: Skipping malformed message %s: %s\n", file, err)
+			continue
+		}
+		store.messages[message.Id] = message
+		if message.Status == OutboxPending {
+			pending++
+		}
+	}
+	if len(store.messages) > 0 {
+		log.Printf("[Outbox] Loaded %d messages from %s, %d pending.\n", len(store.messages), config.Path, pending)
+	}
+	return store, nil
+}
+
+// Path of the file of a message.
+func (s *outboxStore) messagePath(id string) string {
+	return filepath.Join(s.config.Path, id+".json")
+}
+
+// # Put Outbox Message
+//
+// Add or replace a message, evicting the oldest finished one if the store is full.
+// Pending messages are never evicted.
+func (s *outboxStore) put(message OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.messages[message.Id]; !ok && len(s.messages) >= s.config.MaxEntries {
+		for _, oldest := range s.sortedLocked("") {
+			if oldest.Status != OutboxPending {
+				if err := s.removeLocked(oldest.Id); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	if s.config.Path != "" {
+		content, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		// Write to a temporary file first, so that a crash never leaves a truncated message.
+		temp_path := s.messagePath(message.Id) + ".tmp"
+		if err := os.WriteFile(temp_path, content, 0o644); err != nil {
+			return err
+		}
+		if err := os.Rename(temp_path, s.messagePath(message.Id)); err != nil {
+			return err
+		}
+	}
+
+	s.messages[message.Id] = message
+	return nil
+}
+
+func (s *outboxStore) removeLocked(id string) error {
+	if s.config.Path != "" {
+		if err := os.Remove(s.messagePath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	delete(s.messages, id)
+	return nil
+}
+
+// # Get Outbox Message
+func (s *outboxStore) get(id string) (OutboxMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	message, ok := s.messages[id]
+	return message, ok
+}
+
+// # List Outbox Messages
+//
+// Get the messages of the status, every message if empty, the oldest first.
+func (s *outboxStore) list(status string) []OutboxMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedLocked(status)
+}
+
+func (s *outboxStore) sortedLocked(status string) []OutboxMessage {
+	messages := make([]OutboxMessage, 0, len(s.messages))
+	for _, message := range s.messages {
+		if status == "" || message.Status == status {
+			messages = append(messages, message)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].CreatedAt.Before(messages[j].CreatedAt) })
+	return messages
+}
+
+// Claim the pending messages due at `now` which are not being sent and do not wait for another
+// message, and get the time of the next attempt among the others, zero if none.
+// Messages after a failed one are claimed as well, to be marked failed.
+func (s *outboxStore) claimDue(now time.Time) ([]OutboxMessage, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []OutboxMessage
+	var next time.Time
+	for _, message := range s.sortedLocked(OutboxPending) {
+		if _, ok := s.claimed[message.Id]; ok {
+			continue
+		}
+		if before, ok := s.messages[message.After]; ok && before.Status == OutboxPending {
+			continue // Due once the message before it is sent.
+		}
+		if message.NextAttemptAt.After(now) {
+			if next.IsZero() || message.NextAttemptAt.Before(next) {
+				next = message.NextAttemptAt
+			}
+			continue
+		}
+		s.claimed[message.Id] = struct{}{}
+		due = append(due, message)
+	}
+	return due, next
+}
+
+// Mark a message as being sent.
+func (s *outboxStore) claim(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claimed[id] = struct{}{}
+}
+
+func (s *outboxStore) release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.claimed, id)
+}
+
+// Count the messages of the status.
+func (s *outboxStore) count(status string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, message := range s.messages {
+		if message.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// # Outbox Backoff
+//
+// Wait before the next attempt of a message which failed `attempts` times: exponential with
+// jitter between half and the full delay, or longer if TaipeiON asked for it with `Retry-After`.
+func (s *outboxStore) backoff(attempts int, err error) time.Duration {
+	delay := s.config.Backoff
+	for i := 1; i < attempts && delay < s.config.BackoffMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, s.config.BackoffMax)
+	delay = delay/2 + rand.N(delay/2+1)
+
+	var send_err *tp.SendError
+	if errors.As(err, &send_err) && send_err.Response.RetryAfter > delay {
+		delay = send_err.Response.RetryAfter
+	}
+	return delay
+}
+
+// Whether the outbox should send a failed message again.
+// Unlike `tp.IsRetryableSendError`, which only knows the responses of TaipeiON, errors without a
+// response, e.g. network errors, are assumed transient; cancellation is not retried.
+func outboxShouldRetry(err error) bool {
+	var send_err *tp.SendError
+	if errors.As(err, &send_err) {
+		return send_err.Retryable()
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// # Send Through Outbox
+//
+// Record the message in the outbox and send it, returns the ID of the message. Messages failing
+// with a retryable error are sent again in the background by the outbox loop, the caller is not
+// told about it. The error is returned if the message failed for good, e.g. a rejected payload
+// or a cancelled context. Without outbox, the message is sent once.
+//
+// If `after` is the ID of a message still pending, the message is sent once that one is delivered,
+// e.g. the parts of a split text. The message fails without being sent if that one fails.
+func (tpb *TaipeionBot) sendThroughOutbox(ctx context.Context, payload tp.ChannelMessagePayload, target_channel int, after string) (string, error) {
+	if tpb.outbox == nil {
+		return "", tpb.DoEndpointPostRequestContext(ctx, tpb.Endpoint, payload, target_channel)
+	}
+
+	now := time.Now()
+	message := OutboxMessage{
+		Id:            newRequestId(),
+		Channel:       target_channel,
+		Payload:       payload,
+		Status:        OutboxPending,
+		After:         after,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		NextAttemptAt: now,
+	}
+	tpb.outbox.claim(message.Id) // Not picked up by the outbox loop during the first attempt.
+
+	if err := tpb.outbox.put(message); err != nil {
+		tpb.outbox.release(message.Id)
+		log.Printf("[Outbox] Error: Unable to record message (%s), sending it once: %s\n", message.Id, err)
+		return "", tpb.DoEndpointPostRequestContext(ctx, tpb.Endpoint, payload, target_channel)
+	}
+	tpb.Metrics.Inc("outbox_messages_total")
+
+	var err error
+	if before, ok := tpb.outbox.get(after); ok && before.Status == OutboxPending {
+		log.Printf("[Outbox] Message (%s) waits for message (%s).\n", message.Id, after)
+	} else {
+		message, err = tpb.attemptOutboxMessage(ctx, message, false)
+	}
+	tpb.outbox.release(message.Id)
+
+	if message.Status == OutboxFailed {
+		return message.Id, err
+	}
+	if message.Status == OutboxPending {
+		select { // Schedule the retry.
+		case tpb.outbox.wake <- struct{}{}:
+		default:
+		}
+	}
+	return message.Id, nil
+}
+
+// # Attempt Outbox Message
+//
+// Send the message once and record the result. If `keepOnCancel` is set, a send aborted by the
+// context leaves the message pending without counting the attempt, e.g. on shutdown.
+// A message after a failed one is marked failed without being sent.
+func (tpb *TaipeionBot) attemptOutboxMessage(ctx context.Context, message OutboxMessage, keepOnCancel bool) (OutboxMessage, error) {
+	if before, ok := tpb.outbox.get(message.After); ok && before.Status == OutboxFailed {
+		err := fmt.Errorf("message before it (%s) failed", before.Id)
+		message.Status = OutboxFailed
+		message.Error = err.Error()
+		message.UpdatedAt = time.Now()
+		tpb.Metrics.Inc("outbox_failed_total")
+		log.Printf("[Outbox] Error: Message (%s) to channel (%d) not sent: %s\n", message.Id, message.Channel, err)
+
+		if put_err := tpb.outbox.put(message); put_err != nil {
+			log.Printf("[Outbox] Error: Unable to record the status of message (%s): %s\n", message.Id, put_err)
+		}
+		tpb.Metrics.Set("outbox_pending", int64(tpb.outbox.count(OutboxPending)))
+		return message, err
+	}
+
+	err := tpb.DoEndpointPostRequestContext(ctx, tpb.Endpoint, message.Payload, message.Channel)
+	if err != nil && keepOnCancel && ctx.Err() != nil {
+		return message, err
+	}
+
+	message.Attempts++
+	message.UpdatedAt = time.Now()
+	switch {
+	case err == nil:
+		message.Status = OutboxDelivered
+		message.Error = ""
+		tpb.Metrics.Inc("outbox_delivered_total")
+		if message.Attempts > 1 {
+			log.Printf("[Outbox] Message (%s) delivered on attempt %d.\n", message.Id, message.Attempts)
+		}
+
+	case outboxShouldRetry(err) && message.Attempts < tpb.outbox.config.MaxAttempts:
+		delay := tpb.outbox.backoff(message.Attempts, err)
+		message.Error = err.Error()
+		message.NextAttemptAt = message.UpdatedAt.Add(delay)
+		tpb.Metrics.Inc("outbox_retries_total")
+		log.Printf("[Outbox] Message (%s) to channel (%d) failed on attempt %d/%d, retrying in %s: %s\n",
+			message.Id, message.Channel, message.Attempts, tpb.outbox.config.MaxAttempts, delay.Round(time.Millisecond), err)
+
+	default:
+		message.Status = OutboxFailed
+		message.Error = err.Error()
+		tpb.Metrics.Inc("outbox_failed_total")
+		log.Printf("[Outbox] Error: Message (%s) to channel (%d) failed after %d attempts: %s\n", message.Id, message.Channel, message.Attempts, err)
+	}
+
+	if put_err := tpb.outbox.put(message); put_err != nil {
+		log.Printf("[Outbox] Error: Unable to record the status of message (%s): %s\n", message.Id, put_err)
+	}
+	tpb.Metrics.Set("outbox_pending", int64(tpb.outbox.count(OutboxPending)))
+	return message, err
+}
+
+// # Outbox Loop
+//
+// Send the pending messages once they are due, until the context is done.
+// Messages left by the handlers drained on shutdown are sent by `flushOutbox`.
+func (tpb *TaipeionBot) outboxLoop(ctx context.Context) error {
+	if tpb.outbox == nil {
+		<-ctx.Done()
+		return nil
+	}
+
+	log.Println("[Outbox] Outbox loop started.")
+	tpb.sendOutboxMessages(ctx, false)
+	log.Println("[Outbox] Outbox loop stopped.")
+	return nil
+}
+
+// # Flush Outbox
+//
+// Send the pending messages until none is left or the deadline is reached, once the outbox loop
+// is stopped on shutdown. Messages still pending are sent after the next start if the outbox is persisted.
+func (tpb *TaipeionBot) flushOutbox(deadline time.Time) {
+	if tpb.outbox == nil || tpb.outbox.count(OutboxPending) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	log.Printf("[Outbox] Flushing %d pending messages, deadline in %s.\n", tpb.outbox.count(OutboxPending), time.Until(deadline).Round(time.Millisecond))
+	tpb.sendOutboxMessages(ctx, true)
+
+	if pending := tpb.outbox.count(OutboxPending); pending > 0 {
+		log.Printf("[Outbox] Shutdown deadline exceeded, %d pending messages abandoned.\n", pending)
+	}
+}
+
+// Send the messages once they are due, until the context is done.
+// If `untilIdle` is set, return as soon as no message is pending.
+func (tpb *TaipeionBot) sendOutboxMessages(ctx context.Context, untilIdle bool) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tpb.outbox.wake:
+		case <-timer.C:
+		}
+
+		due, next := tpb.outbox.claimDue(time.Now())
+		for _, message := range due {
+			tpb.attemptOutboxMessage(ctx, message, true)
+			tpb.outbox.release(message.Id)
+		}
+
+		timer.Stop()
+		switch {
+		case len(due) > 0:
+			timer.Reset(0) // Look again, the messages just sent may be due before `next`.
+		case !next.IsZero():
+			timer.Reset(time.Until(next))
+		case untilIdle:
+			return
+		}
+	}
+}
+
+// # Outbox Messages
+//
+// List the outbound messages of the status, every message if empty, the oldest first.
+func (tpb *TaipeionBot) OutboxMessages(status string) []OutboxMessage {
+	if tpb.outbox == nil {
+		return nil
+	}
+	return tpb.outbox.list(status)
+}
+
+// # Outbox Message
+//
+// Get an outbound message and its delivery status.
+func (tpb *TaipeionBot) OutboxMessage(id string) (OutboxMessage, error) {
+	if tpb.outbox == nil {
+		return OutboxMessage{}, ErrOutboxMessageNotFound
+	}
+	message, ok := tpb.outbox.get(id)
+	if !ok {
+		return OutboxMessage{}, ErrOutboxMessageNotFound
+	}
+	return message, nil
+}
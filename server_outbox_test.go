@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tp "taipeion/core"
+)
+
+// A chatbot sending to a server answering with the given statuses in turn, then 200.
+func outboxTestBot(t *testing.T, statuses ...int) (*TaipeionBot, *atomic.Int64) {
+	var requests atomic.Int64
+	bot := outboxHandlerTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		if n := int(requests.Add(1)); n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+		}
+	})
+	return bot, &requests
+}
+
+// A chatbot sending through an outbox retrying 3 times to a test endpoint.
+func outboxHandlerTestBot(t *testing.T, handler http.HandlerFunc) *TaipeionBot {
+	var fetches atomic.Int64
+	bot := sendTestBot(t, &fetches, handler)
+	bot.outbox, _ = openOutboxStore(OutboxConfig{MaxAttempts: 3, Backoff: time.Millisecond, BackoffMax: time.Millisecond})
+	return bot
+}
+
+func TestOutboxRetriesTransientFailures(t *testing.T) {
+	bot, requests := outboxTestBot(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bot.outboxLoop(ctx)
+
+	if err := bot.SendPrivateMessage("citizen", "hello", 1); err != nil {
+		t.Fatalf("Expected the message to be queued, got %s", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(bot.OutboxMessages(OutboxDelivered)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the message to be delivered, got %#v", bot.OutboxMessages(""))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	message := bot.OutboxMessages(OutboxDelivered)[0]
+	if message.Attempts != 3 || requests.Load() != 3 || message.Payload.Recipient != "citizen" {
+		t.Errorf("Expected delivery on the third attempt, got %#v", message)
+	}
+}
+
+func TestOutboxFailures(t *testing.T) {
+	// Rejected for good, the caller is told.
+	bot, requests := outboxTestBot(t, http.StatusBadRequest)
+	err := bot.SendPrivateMessage("citizen", "hello", 1)
+	if !errors.Is(err, tp.ErrSendPayloadRejected) || requests.Load() != 1 {
+		t.Errorf("Expected a single attempt rejecting the payload, got %d: %v", requests.Load(), err)
+	}
+	if failed := bot.OutboxMessages(OutboxFailed); len(failed) != 1 || failed[0].Error == "" {
+		t.Errorf("Expected the failure to be recorded, got %#v", failed)
+	}
+
+	// Out of attempts.
+	bot, requests = outboxTestBot(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bot.outboxLoop(ctx)
+
+	bot.SendBroadcastMessage("hello", 1)
+	deadline := time.Now().Add(time.Second)
+	for len(bot.OutboxMessages(OutboxFailed)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the message to fail, got %#v", bot.OutboxMessages(""))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", requests.Load())
+	}
+}
+
+func TestOutboxFlushOnShutdown(t *testing.T) {
+	// Failed while draining, once the outbox loop is stopped.
+	bot, requests := outboxTestBot(t, http.StatusServiceUnavailable)
+	if err := bot.SendPrivateMessage("citizen", "hello", 1); err != nil {
+		t.Fatalf("Expected the message to be queued, got %s", err)
+	}
+
+	bot.flushOutbox(time.Now().Add(time.Second))
+	if delivered := bot.OutboxMessages(OutboxDelivered); len(delivered) != 1 || requests.Load() != 2 {
+		t.Errorf("Expected the message to be delivered by the flush, got %#v", bot.OutboxMessages(""))
+	}
+
+	// Still failing at the deadline, left pending.
+	bot, _ = outboxTestBot(t, http.StatusBadGateway, http.StatusBadGateway)
+	bot.SendPrivateMessage("citizen", "hello", 1)
+	bot.outbox.config.Backoff, bot.outbox.config.BackoffMax = time.Hour, time.Hour
+	bot.SendPrivateMessage("citizen", "hello again", 1)
+
+	bot.flushOutbox(time.Now().Add(50 * time.Millisecond))
+	if pending := bot.OutboxMessages(OutboxPending); len(pending) != 1 || pending[0].Payload.Message.Text != "hello again" {
+		t.Errorf("Expected the message waiting past the deadline to be left pending, got %#v", bot.OutboxMessages(""))
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	store, _ := openOutboxStore(OutboxConfig{Backoff: time.Second, BackoffMax: 10 * time.Second})
+
+	for attempts, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 10 * time.Second} {
+		for i := 0; i < 20; i++ {
+			if delay := store.backoff(attempts, errors.New("timeout")); delay < expected/2 || delay > expected {
+				t.Errorf("Attempt %d: expected a delay between %s and %s, got %s", attempts, expected/2, expected, delay)
+			}
+		}
+	}
+
+	throttled := &tp.SendError{Kind: tp.ErrSendRateLimited, Response: tp.SendResponse{StatusCode: 429, RetryAfter: time.Minute}}
+	if delay := store.backoff(1, throttled); delay != time.Minute {
+		t.Errorf("Expected to wait as asked by Retry-After, got %s", delay)
+	}
+}
+
+func TestOutboxPersistence(t *testing.T) {
+	dir := t.TempDir()
+	store, err := openOutboxStore(OutboxConfig{Path: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := OutboxMessage{Id: "pending", Channel: 1, Status: OutboxPending, CreatedAt: time.Now()}
+	message.Payload = tp.ChannelMessagePayload{Ask: "sendMessage", Recipient: "citizen", Message: tp.NewTextMessage("hello")}
+	if err := store.put(message); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := openOutboxStore(OutboxConfig{Path: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	due, _ := reopened.claimDue(time.Now())
+	if len(due) != 1 || due[0].Payload.Message.Text != "hello" {
+		t.Errorf("Expected the pending message to be due after a restart, got %#v", due)
+	}
+}
+
+func TestOutboxOpenError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0o644)
+
+	if _, err := NewChatbotFromConfig(ServerConfig{Outbox: OutboxConfig{Path: filepath.Join(file, "outbox")}}); err == nil {
+		t.Errorf("Expected an unusable outbox directory to be rejected")
+	}
+}
@@ -1,19 +1,33 @@
 package main
 
 import (
-	tp "taipeion/core"
-
-	api_platform "github.com/h-alice/tcg-api-platform-client"
+	"context"
+	"net/http"
+	"sync"
+	"time"
 
-	"golang.org/x/sync/semaphore"
+	tp "taipeion/core"
 )
 
 // The prototype of the webhook event callback.
 type WebhookEventCallback func(*TaipeionBot, ChatbotWebhookEvent) error
 
 type eventHandlerEntry struct {
-	Callback   WebhookEventCallback // The callback function.
-	IsPriority bool                 // Indicates if the callback is a priority callback (bypass the concuurancy limit).
+	Callback        WebhookEventCallback // The callback function.
+	ContextCallback ContextEventCallback // The context-aware callback function, takes precedence over `Callback`.
+	Name            string               // Name of the handler, used in logs and metrics.
+	Priority        string               // The priority class of the handler, see `PriorityClassConfig`.
+	Timeout         time.Duration        // Deadline of a call, the global handler timeout is used if zero.
+	MaxAttempts     int                  // Attempts of a failed call, the global handler retry setting is used if zero.
+	Matchers        []EventMatcher       // The callback is called only on events matching all matchers.
+	Middlewares     []Middleware         // Middlewares of this handler, applied inside of the global ones.
+	SerialKey       string               // Events of the same key are handled in order, see `SerializeBy`.
+	InFlightPolicy  string               // What happens to an event arriving while one of the same key is in flight.
+	WaitNotifier    WaitNotifier         // Tells the user the position while the event waits for a slot.
+	RateLimited     bool                 // Skipped for users over their rate limit.
+
+	index        int  // Registration index, identifies the handler.
+	explicitName bool // The name is set with `Named`, and must be unique.
 }
 
 // Define a struct for the response
@@ -23,38 +37,108 @@ type response struct {
 
 // Definiton of the channel struct.
 type Channel struct {
-	ChannelSecret        string `yaml:"channel-secret"`       // The secret of the channel, get it from TaipeiON admin panel.
-	ChannelAccessToken   string `yaml:"channel-access-token"` // The access token of the channel.
-	ChannelLlmEndpoint   string `yaml:"llm-endpoint"`         // The endpoint of the LLM server for this channel.
-	ChannelTriggerPrefix string `yaml:"trigger-word"`         // The trigger word for this channel.
+	ChannelSecret         string  `yaml:"channel-secret"`          // The secret of the channel, get it from TaipeiON admin panel.
+	ChannelAccessToken    string  `yaml:"channel-access-token"`    // The access token of the channel.
+	ChannelLlmEndpoint    string  `yaml:"llm-endpoint"`            // The endpoint of the LLM server for this channel.
+	ChannelTriggerPrefix  string  `yaml:"trigger-word"`            // The trigger word for this channel.
+	WelcomeMessage        string  `yaml:"welcome-message"`         // Optional message sent to new subscribers.
+	WebhookPath           string  `yaml:"webhook-path"`            // Optional webhook path of this channel, overrides the server-wide path.
+	MaxConcurrentHandlers int     `yaml:"max-concurrent-handlers"` // Optional, running handlers of this channel, within `max-concurrent-event-handlers`.
+	SchedulingWeight      int     `yaml:"scheduling-weight"`       // Share of the handler slots when channels compete, defaults to 1.
+	RateLimitPerMinute    float64 `yaml:"rate-limit-per-minute"`   // Optional, events allowed per user and minute, overrides the server-wide rate.
+	RateLimitBurst        int     `yaml:"rate-limit-burst"`        // Optional, events allowed in a burst per user, overrides the server-wide burst.
+	OutboundPerSecond     float64 `yaml:"outbound-per-second"`     // Optional, messages sent to this channel per second, unlimited if zero.
+	OutboundBurst         int     `yaml:"outbound-burst"`          // Optional, messages sent to this channel at once after an idle period.
 }
 
 type ChannelIdConfigMap map[int]Channel // A map from channel ID to channel configuration.
 
 type ServerConfig struct {
-	Endpoint               string             `yaml:"taipeion-endpoint"`             // The endpoint of the Taipeion server.
-	Channels               ChannelIdConfigMap `yaml:"channels"`                      // The configuration of the channels.
-	Address                string             `yaml:"address"`                       // Local IP to listen on.
-	Port                   int16              `yaml:"port"`                          // Local port to listen on.
-	ApiPlatformEndpoint    string             `yaml:"api-platform-endpoint"`         // The endpoint of the API platform.
-	ApiPlatformClientId    string             `yaml:"api-platform-client-id"`        // The client ID of the API platform.
-	ApiPlatformClientToken string             `yaml:"api-platform-client-token"`     // The client token of the API platform.
-	MaxConcurrentEvent     int                `yaml:"max-concurrent-event-handlers"` // Maximum number of concurrent event handlers.
+	Endpoint               string                  `yaml:"taipeion-endpoint"`             // The endpoint of the Taipeion server.
+	Channels               ChannelIdConfigMap      `yaml:"channels"`                      // The configuration of the channels.
+	Address                string                  `yaml:"address"`                       // Local IP to listen on.
+	Port                   int16                   `yaml:"port"`                          // Local port to listen on.
+	ApiPlatformEndpoint    string                  `yaml:"api-platform-endpoint"`         // The endpoint of the API platform.
+	ApiPlatformClientId    string                  `yaml:"api-platform-client-id"`        // The client ID of the API platform.
+	ApiPlatformClientToken string                  `yaml:"api-platform-client-token"`     // The client token of the API platform.
+	Credentials            CredentialConfig        `yaml:"credentials"`                   // Caching of the API platform credentials.
+	MaxConcurrentEvent     int                     `yaml:"max-concurrent-event-handlers"` // Maximum number of concurrent event handlers.
+	PriorityClasses        []PriorityClassConfig   `yaml:"priority-classes"`              // Priority classes of the handlers, in addition to the built-in ones.
+	Serialization          SerializationConfig     `yaml:"serialization"`                 // Ordering of the events of the same user or channel.
+	WaitProgressInterval   time.Duration           `yaml:"wait-progress-interval"`        // Interval of the position updates sent to waiting users, disabled if zero.
+	RateLimit              RateLimitConfig         `yaml:"rate-limit"`                    // Rate limit of the users, for handlers registered `WithRateLimit`.
+	OutboundRateLimit      OutboundRateLimitConfig `yaml:"outbound-rate-limit"`           // Rate limit of the messages sent to TaipeiON across all channels.
+	MessageSplit           MessageSplitConfig      `yaml:"message-split"`                 // Splitting of long text messages.
+	RoutingMode            RoutingMode             `yaml:"routing-mode"`                  // Dispatch events to every matching handler (`fan-out`) or the first one (`first-match`).
+	HandlerTimeout         time.Duration           `yaml:"handler-timeout"`               // Maximum duration of a handler call, unlimited if zero.
+	BlockedUsers           []string                `yaml:"blocked-users"`                 // Users whose events are ignored.
+	ErrorReport            ErrorReportConfig       `yaml:"error-report"`                  // Where the failures of the handlers are reported.
+	HandlerRetry           RetryConfig             `yaml:"handler-retry"`                 // Retry of failed handlers.
+	DeadLetter             DeadLetterConfig        `yaml:"dead-letter"`                   // Store of the events whose handler failed after all attempts.
+	Outbox                 OutboxConfig            `yaml:"outbox"`                        // Retry of failed outbound messages.
+	AdminPath              string                  `yaml:"admin-path"`                    // Path prefix of the admin endpoints, disabled if empty.
+	AdminToken             string                  `yaml:"admin-token"`                   // Bearer token required by the admin endpoints.
+	SignatureHeader        string                  `yaml:"webhook-signature-header"`      // The header carrying the webhook signature.
+	SkipSignatureCheck     bool                    `yaml:"insecure-skip-signature-check"` // Skip the webhook signature check, for local testing only.
+	WebhookPath            string                  `yaml:"webhook-path"`                  // The webhook path, `{channelId}` is replaced by each channel's ID.
+	MetricsPath            string                  `yaml:"metrics-path"`                  // Path serving the metrics snapshot, disabled if empty.
+	EventQueue             EventQueueConfig        `yaml:"event-queue"`                   // The configuration of the event queue.
+	Dedup                  DedupConfig             `yaml:"dedup"`                         // The configuration of the event deduplication.
+	ShutdownTimeout        time.Duration           `yaml:"shutdown-timeout"`              // Maximum time to drain the queue and wait for running handlers on shutdown.
+	MaxSubsystemRestarts   int                     `yaml:"max-subsystem-restarts"`        // Consecutive failures of a subsystem before giving up.
+	RestartBackoff         time.Duration           `yaml:"restart-backoff"`               // Backoff before the first restart of a failed subsystem.
+	RestartBackoffMax      time.Duration           `yaml:"restart-backoff-max"`           // Upper bound of the restart backoff.
 }
 
 type ChatbotWebhookEvent struct {
-	Destination     int // ID of incoming channel. Since the Destination field is not in the event object, we need to add it.
-	tp.MessageEvent     // The message event.
+	Destination     int    `json:"destination"` // ID of incoming channel. Since the Destination field is not in the event object, we need to add it.
+	RequestId       string `json:"request_id"`  // ID of the webhook request the event was received with.
+	tp.MessageEvent        // The message event.
 }
 
 type TaipeionBot struct {
-	Endpoint       string                          // The endpoint of the Taipeion server.
-	Channels       map[int]Channel                 // A map from channel ID to channel configuration.
-	ServerAddress  string                          // The address to listen on.
-	ServerPort     int16                           // The port to listen on.
-	eventQueue     chan ChatbotWebhookEvent        // Event queue, every incoming event will be put into this queue.
-	eventHandlers  []eventHandlerEntry             // Event handlers.
-	eventSemaphore *semaphore.Weighted             // Semaphore for event handlers.
-	maxConcurrent  int                             // Maximum number of concurrent event handlers.
-	api_client     *api_platform.ApiPlatformClient // Insrance of the API platform client.
+	Endpoint             string              // The endpoint of the Taipeion server.
+	Channels             map[int]Channel     // A map from channel ID to channel configuration.
+	ServerAddress        string              // The address to listen on.
+	ServerPort           int16               // The port to listen on.
+	eventQueue           EventQueue          // Event queue, every incoming event will be put into this queue.
+	eventHandlers        []eventHandlerEntry // Event handlers.
+	registrationErr      error               // Invalid handler registrations, returned by `Start`.
+	routingMode          RoutingMode         // How events are dispatched to handlers.
+	middlewares          []Middleware        // Middlewares applied to every handler.
+	errorSinks           []ErrorSink         // Receive the failures of the handlers.
+	retry                RetryConfig         // Retry of failed handlers.
+	deadLetters          *deadLetterStore    // Events whose handler failed after all attempts, nil if disabled.
+	outbox               *outboxStore        // Outbound messages and their delivery status, nil if disabled.
+	handlerTimeout       time.Duration       // Default deadline of a handler call, unlimited if zero.
+	handlersCtx          context.Context     // Parent context of every handler call, cancelled upon shutdown deadline.
+	cancelHandlers       context.CancelFunc  // Cancel the running handlers.
+	httpClient           *http.Client        // Client of outbound requests.
+	scheduler            *priorityScheduler  // Grants handler slots by priority class.
+	serialization        SerializationConfig // Ordering of the events of the same user or channel.
+	serialLanes          *serialLanes        // Events in flight and waiting, per handler and key.
+	latency              *latencyTracker     // Recent call durations of the handlers.
+	rateLimiter          *rateLimiter        // Rate limit of the users.
+	outboundLimiter      *outboundLimiter    // Rate limit of the messages sent to TaipeiON.
+	messageSplit         MessageSplitConfig  // Splitting of long text messages.
+	waitProgressInterval time.Duration       // Interval of the position updates of waiting events, disabled if zero.
+	credentials          *credentialManager  // Cached access token and sign block of the API platform.
+
+	webhookPath        string             // The webhook path template.
+	metricsPath        string             // Path serving the metrics snapshot.
+	adminPath          string             // Path prefix of the admin endpoints.
+	adminToken         string             // Bearer token required by the admin endpoints.
+	signatureHeader    string             // The header carrying the webhook signature.
+	skipSignatureCheck bool               // Skip the webhook signature check.
+	eventQueueConfig   EventQueueConfig   // The configuration of the event queue.
+	overflow           overflowPolicy     // What to do when the event queue is full.
+	dedup              *eventDeduplicator // Suppresses redelivered events, nil if disabled.
+	shutdownTimeout    time.Duration      // Deadline for draining the queue on shutdown.
+	runningHandlers    sync.WaitGroup     // Tracks the running event handlers.
+	runningCount       int64              // Number of running event handlers.
+	supervisor         *supervisor        // Supervisor of the listener and the processor.
+	maxRestarts        int                // Consecutive failures of a subsystem before giving up.
+	restartBackoff     time.Duration      // Backoff before the first restart.
+	restartBackoffMax  time.Duration      // Upper bound of the restart backoff.
+	Metrics            *MetricsRegistry   // Counters and gauges of the chatbot.
 }
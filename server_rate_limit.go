@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRateLimitPerMinute  = 6
+	defaultRateLimitBurst      = 3
+	defaultRateLimitBanAfter   = 10
+	defaultRateLimitBanTime    = 10 * time.Minute
+	defaultRateLimitBanMax     = 24 * time.Hour
+	defaultRateLimitForgetTime = 24 * time.Hour // Offences are forgotten after a day of good behaviour, counted from the end of the last ban.
+	defaultThrottleMessage     = "您傳送訊息的速度過快，請稍後再試。"
+	defaultBanMessage          = "由於短時間內傳送過多訊息，您的使用權限已暫停%s。"
+
+	rateLimitSweepInterval = 1000 // Idle buckets are removed every this many checks.
+)
+
+// # Rate Limit Configuration
+//
+// Limit the events of each user on each channel with a token bucket, for handlers registered `WithRateLimit`.
+// Users throttled repeatedly are banned for a while, longer on each ban.
+type RateLimitConfig struct {
+	PerMinute       float64       `yaml:"per-minute"`       // Tokens added per minute.
+	Burst           int           `yaml:"burst"`            // Size of the bucket.
+	BanAfter        int           `yaml:"ban-after"`        // Throttled events before a ban, bans are disabled if negative.
+	BanDuration     time.Duration `yaml:"ban-duration"`     // Duration of the first ban, doubled on each further ban.
+	BanMax          time.Duration `yaml:"ban-max"`          // Upper bound of the ban duration.
+	ThrottleMessage string        `yaml:"throttle-message"` // Reply to the first throttled event of a streak.
+	BanMessage      string        `yaml:"ban-message"`      // Reply when the user is banned, `%s` is the duration.
+	ExemptUsers     []string      `yaml:"exempt-users"`     // Users never throttled, e.g. operators.
+}
+
+// Fill zero values of the rate limit configuration with defaults.
+func newRateLimitConfig(config RateLimitConfig) RateLimitConfig {
+	if config.PerMinute <= 0 {
+		config.PerMinute = defaultRateLimitPerMinute
+	}
+	if config.Burst <= 0 {
+		config.Burst = defaultRateLimitBurst
+	}
+	if config.BanAfter == 0 {
+		config.BanAfter = defaultRateLimitBanAfter
+	}
+	if config.BanDuration <= 0 {
+		config.BanDuration = defaultRateLimitBanTime
+	}
+	if config.BanMax <= 0 {
+		config.BanMax = defaultRateLimitBanMax
+	}
+	if config.ThrottleMessage == "" {
+		config.ThrottleMessage = defaultThrottleMessage
+	}
+	if config.BanMessage == "" {
+		config.BanMessage = defaultBanMessage
+	}
+	return config
+}
+
+// The verdict of the rate limiter.
+type rateLimitVerdict int
+
+const (
+	rateLimitAllowed   rateLimitVerdict = iota
+	rateLimitThrottled                  // Throttled, the user should be told.
+	rateLimitSilenced                   // Throttled again or banned, nothing to tell.
+	rateLimitBanned                     // Banned by this event, the user should be told.
+)
+
+type rateLimitBucket struct {
+	tokens      float64
+	updated     time.Time
+	throttled   int // Throttled events since the last allowed one or ban.
+	bans        int // Bans so far, makes the next one longer.
+	bannedUntil time.Time
+	lastOffence time.Time
+}
+
+// Whether the offences of the bucket are forgotten: neither an offence nor the end of a ban
+// in the forget window.
+func (b *rateLimitBucket) forgotten(now time.Time) bool {
+	return now.Sub(b.lastOffence) > defaultRateLimitForgetTime && now.Sub(b.bannedUntil) > defaultRateLimitForgetTime
+}
+
+// # Rate Limiter
+//
+// Token buckets keyed by (channel, user), with per-channel rates.
+type rateLimiter struct {
+	mu       sync.Mutex
+	config   RateLimitConfig
+	channels map[int]Channel
+	exempt   map[string]struct{}
+	buckets  map[string]*rateLimitBucket
+	checks   int
+	now      func() time.Time
+}
+
+func newRateLimiter(config RateLimitConfig, channels map[int]Channel) *rateLimiter {
+	config = newRateLimitConfig(config)
+	limiter := &rateLimiter{
+		config:   config,
+		channels: channels,
+		exempt:   make(map[string]struct{}, len(config.ExemptUsers)),
+		buckets:  make(map[string]*rateLimitBucket),
+		now:      time.Now,
+	}
+	for _, user := range config.ExemptUsers {
+		limiter.exempt[user] = struct{}{}
+	}
+	return limiter
+}
+
+// Get the rate and the burst of the channel.
+func (l *rateLimiter) limits(channel int) (float64, int) {
+	per_minute, burst := l.config.PerMinute, l.config.Burst
+	if config, ok := l.channels[channel]; ok {
+		if config.RateLimitPerMinute > 0 {
+			per_minute = config.RateLimitPerMinute
+		}
+		if config.RateLimitBurst > 0 {
+			burst = config.RateLimitBurst
+		}
+	}
+	return per_minute, burst
+}
+
+// # Check Rate Limit
+//
+// Take a token for the event of the user on the channel, returns the verdict and the ban duration if banned.
+func (l *rateLimiter) check(channel int, user string) (rateLimitVerdict, time.Duration) {
+	if _, ok := l.exempt[user]; ok || user == "" {
+		return rateLimitAllowed, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.checks++
+	if l.checks%rateLimitSweepInterval == 0 {
+		l.sweepLocked(now)
+	}
+
+	per_minute, burst := l.limits(channel)
+	key := fmt.Sprintf("%d|%s", channel, user)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &rateLimitBucket{tokens: float64(burst), updated: now}
+		l.buckets[key] = bucket
+	}
+
+	if now.Before(bucket.bannedUntil) {
+		return rateLimitSilenced, 0
+	}
+	if bucket.bans > 0 && bucket.forgotten(now) {
+		bucket.bans = 0
+	}
+
+	// Refill the bucket.
+	bucket.tokens = min(float64(burst), bucket.tokens+now.Sub(bucket.updated).Minutes()*per_minute)
+	bucket.updated = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.throttled = 0
+		return rateLimitAllowed, 0
+	}
+
+	bucket.throttled++
+	bucket.lastOffence = now
+	if l.config.BanAfter > 0 && bucket.throttled >= l.config.BanAfter {
+		// Doubled on each ban until the upper bound, which stops the escalation.
+		duration := l.config.BanDuration
+		for i := 0; i < bucket.bans && duration < l.config.BanMax; i++ {
+			duration *= 2
+		}
+		duration = min(duration, l.config.BanMax)
+		if duration < l.config.BanMax {
+			bucket.bans++
+		}
+		bucket.bannedUntil = now.Add(duration)
+		bucket.throttled = 0
+		return rateLimitBanned, duration
+	}
+	if bucket.throttled == 1 {
+		return rateLimitThrottled, 0
+	}
+	return rateLimitSilenced, 0
+}
+
+// Remove the buckets which are full and have nothing to remember.
+func (l *rateLimiter) sweepLocked(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.forgotten(now) && now.Sub(bucket.updated) > time.Hour {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// # Rate Limit Handler
+//
+// Return a copy of the entry skipped for events of users over their rate limit, see `RateLimitConfig`.
+// One token is taken per event, however many rate limited handlers it matches.
+func (entry eventHandlerEntry) WithRateLimit() eventHandlerEntry {
+	entry.RateLimited = true
+	return entry
+}
+
+// # Apply Rate Limit
+//
+// Remove the rate limited handlers if the user of the event is over the limit, and tell the user why.
+func (tpb *TaipeionBot) applyRateLimit(event ChatbotWebhookEvent, handlers []eventHandlerEntry) []eventHandlerEntry {
+	limited := false
+	for _, handler := range handlers {
+		limited = limited || handler.RateLimited
+	}
+	if !limited {
+		return handlers
+	}
+
+	verdict, ban_duration := tpb.rateLimiter.check(event.Destination, event.Source.UserId)
+	if verdict == rateLimitAllowed {
+		return handlers
+	}
+
+	user_id := event.Source.UserId
+	tpb.Metrics.Inc("rate_limited_events_total")
+
+	var reply string
+	switch verdict {
+	case rateLimitThrottled:
+		log.Printf("[EvProcessor] Throttling user (%s) on channel (%d).\n", user_id, event.Destination)
+		reply = tpb.rateLimiter.config.ThrottleMessage
+	case rateLimitBanned:
+		log.Printf("[EvProcessor] Banning user (%s) on channel (%d) for %s.\n", user_id, event.Destination, ban_duration)
+		tpb.Metrics.Inc("rate_limit_bans_total")
+		reply = fmt.Sprintf(tpb.rateLimiter.config.BanMessage, formatWaitDuration(ban_duration))
+	}
+	if reply != "" {
+		tpb.sendPrivateMessageInBackground(user_id, reply, event.Destination, "rate limit notice") // Do not hold the processor.
+	}
+
+	remaining := handlers[:0:0]
+	for _, handler := range handlers {
+		if !handler.RateLimited {
+			remaining = append(remaining, handler)
+		}
+	}
+	return remaining
+}
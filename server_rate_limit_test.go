@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterEscalatingBans(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newRateLimiter(RateLimitConfig{
+		PerMinute:   1,
+		Burst:       2,
+		BanAfter:    3,
+		BanDuration: time.Minute,
+		ExemptUsers: []string{"operator"},
+	}, map[int]Channel{2: {RateLimitBurst: 5}})
+	limiter.now = func() time.Time { return now }
+
+	expect := func(user string, channel int, expected rateLimitVerdict) time.Duration {
+		t.Helper()
+		verdict, duration := limiter.check(channel, user)
+		if verdict != expected {
+			t.Fatalf("Expected verdict %d, got %d", expected, verdict)
+		}
+		return duration
+	}
+
+	expect("citizen", 1, rateLimitAllowed)
+	expect("citizen", 1, rateLimitAllowed)
+	expect("citizen", 1, rateLimitThrottled) // Told once.
+	expect("citizen", 1, rateLimitSilenced)
+	if duration := expect("citizen", 1, rateLimitBanned); duration != time.Minute {
+		t.Errorf("Expected a ban of a minute, got %s", duration)
+	}
+	expect("citizen", 1, rateLimitSilenced) // Banned.
+
+	// The bucket refills after the ban, the next ban is longer.
+	now = now.Add(3 * time.Minute)
+	expect("citizen", 1, rateLimitAllowed)
+	expect("citizen", 1, rateLimitAllowed)
+	expect("citizen", 1, rateLimitThrottled)
+	expect("citizen", 1, rateLimitSilenced)
+	if duration := expect("citizen", 1, rateLimitBanned); duration != 2*time.Minute {
+		t.Errorf("Expected a ban of two minutes, got %s", duration)
+	}
+
+	// Other channels have their own buckets and limits, operators are never throttled.
+	for i := 0; i < 5; i++ {
+		expect("citizen", 2, rateLimitAllowed)
+		expect("operator", 1, rateLimitAllowed)
+	}
+}
+
+func TestRateLimiterRememberBansAfterLongBan(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newRateLimiter(RateLimitConfig{PerMinute: 1, Burst: 1, BanAfter: 1, BanDuration: 25 * time.Hour, BanMax: 100 * time.Hour}, nil)
+	limiter.now = func() time.Time { return now }
+
+	abuse := func() time.Duration {
+		t.Helper()
+		limiter.check(1, "citizen")
+		verdict, duration := limiter.check(1, "citizen")
+		if verdict != rateLimitBanned {
+			t.Fatalf("Expected a ban, got verdict %d", verdict)
+		}
+		return duration
+	}
+
+	if duration := abuse(); duration != 25*time.Hour {
+		t.Fatalf("Expected a ban of 25h, got %s", duration)
+	}
+
+	// Re-offending as soon as the ban ends, longer than the forget time after the offence.
+	now = now.Add(25*time.Hour + time.Minute)
+	if duration := abuse(); duration != 50*time.Hour {
+		t.Errorf("Expected the ban to escalate to 50h, got %s", duration)
+	}
+
+	// A day of good behaviour after the end of the ban is forgiven.
+	now = now.Add(50*time.Hour + 25*time.Hour)
+	if duration := abuse(); duration != 25*time.Hour {
+		t.Errorf("Expected the offences to be forgotten, got a ban of %s", duration)
+	}
+}
+
+func TestRateLimiterBanBound(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newRateLimiter(RateLimitConfig{PerMinute: 1, Burst: 1, BanAfter: 1, BanDuration: time.Hour, BanMax: 365 * 24 * time.Hour}, nil)
+	limiter.now = func() time.Time { return now }
+
+	// Far more bans than the bits of a duration, each one right after the previous ended.
+	for i := 0; i < 100; i++ {
+		limiter.check(1, "citizen")
+		verdict, duration := limiter.check(1, "citizen")
+		if verdict != rateLimitBanned || duration <= 0 || duration > 365*24*time.Hour {
+			t.Fatalf("Ban %d: expected a ban within the bound, got verdict %d for %s", i+1, verdict, duration)
+		}
+		if i >= 20 && duration != 365*24*time.Hour {
+			t.Fatalf("Ban %d: expected the longest ban, got %s", i+1, duration)
+		}
+		now = now.Add(duration + time.Minute)
+	}
+}
+
+func TestApplyRateLimit(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	bot.rateLimiter = newRateLimiter(RateLimitConfig{Burst: 1, ThrottleMessage: "slow down"}, nil)
+
+	noop := func(bot *TaipeionBot, event ChatbotWebhookEvent) error { return nil }
+	handlers := []eventHandlerEntry{
+		ScheduleCallbackNormalPriority(noop).Named("llm").WithRateLimit(),
+		ScheduleCallbackHighestPriority(noop).Named("log"),
+	}
+
+	event := textEvent(1, "hello")
+	event.Source.UserId = "citizen"
+	if remaining := bot.applyRateLimit(event, handlers); len(remaining) != 2 {
+		t.Errorf("Expected every handler to run, got %d", len(remaining))
+	}
+	if remaining := bot.applyRateLimit(event, handlers); len(remaining) != 1 || remaining[0].Name != "log" {
+		t.Errorf("Expected only the handler without rate limit to run, got %v", remaining)
+	}
+	if bot.Metrics.Get("rate_limited_events_total") != 1 {
+		t.Errorf("Expected the throttled event to be counted")
+	}
+	bot.runningHandlers.Wait() // The notice is sent in the background.
+}
+
+func TestFormatBanDuration(t *testing.T) {
+	for duration, expected := range map[time.Duration]string{
+		30 * time.Second:     "約 30 秒",
+		10 * time.Minute:     "約 10 分鐘",
+		6 * time.Hour:        "約 6 小時",
+		7 * 24 * time.Hour:   "約 7 天",
+		365 * 24 * time.Hour: "約 365 天",
+	} {
+		if formatted := formatWaitDuration(duration); formatted != expected {
+			t.Errorf("Expected %q for %s, got %q", expected, duration, formatted)
+		}
+	}
+}
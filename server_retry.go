@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	tp "taipeion/core"
+)
+
+const (
+	defaultRetryMaxAttempts = 1
+	defaultRetryBackoff     = 2 * time.Second
+	defaultRetryBackoffMax  = time.Minute
+)
+
+// The handler failed in a way that retrying cannot fix, e.g. malformed input.
+// Wrap it in the returned error to skip the remaining attempts: `fmt.Errorf("%w: ...", ErrNoRetry)`.
+var ErrNoRetry = errors.New("not retryable")
+
+// # Handler Retry Configuration
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"max-attempts"` // Attempts of a failed handler, including the first one, unless set with `WithRetry`. 1 disables the retry.
+	Backoff     time.Duration `yaml:"backoff"`      // Wait before the first retry, doubled on each attempt.
+	BackoffMax  time.Duration `yaml:"backoff-max"`  // Upper bound of the wait between attempts.
+}
+
+// Fill zero values of the retry configuration with defaults.
+func newRetryConfig(config RetryConfig) RetryConfig {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaultRetryBackoff
+	}
+	if config.BackoffMax <= 0 {
+		config.BackoffMax = defaultRetryBackoffMax
+	}
+	return config
+}
+
+// Whether a failed handler call should be attempted again.
+// Panics are considered bugs, and cancellation means the chatbot is shutting down.
+// Messages rejected by TaipeiON for good, e.g. a rejected payload, are not sent again.
+func isRetryableHandlerError(err error) bool {
+	var send_err *tp.SendError
+	if errors.As(err, &send_err) && !send_err.Retryable() {
+		return false // Sending the same message again would be rejected again.
+	}
+	return !errors.Is(err, ErrNoRetry) && !errors.Is(err, ErrHandlerPanic) && !errors.Is(err, context.Canceled)
+}
+
+// # Execute Event Handler
+//
+// Call the handler, retrying failed calls with exponential backoff.
+// Once the attempts are exhausted, the failure is reported to the error sinks and
+// the event is parked in the dead-letter store. `letterId` is the ID of the replayed
+// dead letter, empty for new events.
+//
+// The handler slot is released between attempts, and the wait is aborted once `ctx` is done.
+// A call cancelled because a newer event replaced it is neither reported nor parked.
+func (tpb *TaipeionBot) executeEventHandler(ctx context.Context, entry eventHandlerEntry, event ChatbotWebhookEvent, letterId string) error {
+	backoff := tpb.retry.Backoff
+	max_attempts := tpb.retry.MaxAttempts
+	if entry.MaxAttempts > 0 {
+		max_attempts = entry.MaxAttempts
+	}
+
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		err = tpb.runEventHandler(ctx, entry, event)
+		if err == nil || attempts >= max_attempts || !isRetryableHandlerError(err) {
+			break
+		}
+
+		log.Printf("[EvProcessor] Handler (%s) failed on request (%s), attempt %d/%d, retrying in %s: %s\n",
+			entry.Name, event.RequestId, attempts, max_attempts, backoff, err)
+		tpb.Metrics.Inc("handler_retries_total")
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			err = ctx.Err()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		backoff = min(backoff*2, tpb.retry.BackoffMax)
+	}
+
+	if err != nil && errors.Is(context.Cause(ctx), ErrEventReplaced) {
+		log.Printf("[EvProcessor] Handler (%s) on request (%s) was replaced by a newer event.\n", entry.Name, event.RequestId)
+		return err
+	}
+	if err != nil {
+		tpb.reportHandlerError(HandlerError{Handler: entry.Name, RequestId: event.RequestId, Event: event, Err: err, Attempts: attempts})
+		tpb.parkDeadLetter(entry, event, err, attempts, letterId)
+	}
+	return err
+}
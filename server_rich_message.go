@@ -0,0 +1,58 @@
+package main
+
+import (
+	tp "taipeion/core"
+)
+
+// Senders of the rich message types, see the constructors in the core package for details.
+// For other templates, craft the message with the core constructors and use `SendPrivate` or `SendBroadcast`.
+
+// # Private image sender
+func (tpb *TaipeionBot) SendPrivateImage(userId string, originalContentUrl string, previewImageUrl string, target_channel int) error {
+	return tpb.SendPrivate(userId, tp.NewImageMessage(originalContentUrl, previewImageUrl), target_channel)
+}
+
+// # Broadcast image sender
+func (tpb *TaipeionBot) SendBroadcastImage(originalContentUrl string, previewImageUrl string, target_channel int) error {
+	return tpb.SendBroadcast(tp.NewImageMessage(originalContentUrl, previewImageUrl), target_channel)
+}
+
+// # Private file sender
+func (tpb *TaipeionBot) SendPrivateFile(userId string, fileUrl string, fileName string, target_channel int) error {
+	return tpb.SendPrivate(userId, tp.NewFileMessage(fileUrl, fileName), target_channel)
+}
+
+// # Broadcast file sender
+func (tpb *TaipeionBot) SendBroadcastFile(fileUrl string, fileName string, target_channel int) error {
+	return tpb.SendBroadcast(tp.NewFileMessage(fileUrl, fileName), target_channel)
+}
+
+// # Private sticker sender
+func (tpb *TaipeionBot) SendPrivateSticker(userId string, packageId string, stickerId string, target_channel int) error {
+	return tpb.SendPrivate(userId, tp.NewStickerMessage(packageId, stickerId), target_channel)
+}
+
+// # Broadcast sticker sender
+func (tpb *TaipeionBot) SendBroadcastSticker(packageId string, stickerId string, target_channel int) error {
+	return tpb.SendBroadcast(tp.NewStickerMessage(packageId, stickerId), target_channel)
+}
+
+// # Private link card sender
+func (tpb *TaipeionBot) SendPrivateLink(userId string, url string, title string, description string, thumbnailUrl string, target_channel int) error {
+	return tpb.SendPrivate(userId, tp.NewLinkMessage(url, title, description, thumbnailUrl), target_channel)
+}
+
+// # Broadcast link card sender
+func (tpb *TaipeionBot) SendBroadcastLink(url string, title string, description string, thumbnailUrl string, target_channel int) error {
+	return tpb.SendBroadcast(tp.NewLinkMessage(url, title, description, thumbnailUrl), target_channel)
+}
+
+// # Private buttons template sender
+func (tpb *TaipeionBot) SendPrivateButtons(userId string, altText string, title string, text string, actions []tp.TemplateAction, target_channel int) error {
+	return tpb.SendPrivate(userId, tp.NewButtonsTemplateMessage(altText, title, text, actions...), target_channel)
+}
+
+// # Broadcast buttons template sender
+func (tpb *TaipeionBot) SendBroadcastButtons(altText string, title string, text string, actions []tp.TemplateAction, target_channel int) error {
+	return tpb.SendBroadcast(tp.NewButtonsTemplateMessage(altText, title, text, actions...), target_channel)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	tp "taipeion/core"
+)
+
+// # Event Matcher
+//
+// A predicate deciding whether a handler applies to an event.
+// Handlers whose matchers do not all hold are never started.
+type EventMatcher func(bot *TaipeionBot, event ChatbotWebhookEvent) bool
+
+// # Routing Mode
+//
+// Decide how many handlers an event is dispatched to.
+type RoutingMode string
+
+const (
+	RouteFanOut     RoutingMode = "fan-out"     // Every matching handler is called.
+	RouteFirstMatch RoutingMode = "first-match" // Only the first matching handler, in registration order, is called.
+)
+
+// # Match Channels
+//
+// Match events received on one of the channels.
+func MatchChannels(channelIds ...int) EventMatcher {
+	return func(bot *TaipeionBot, event ChatbotWebhookEvent) bool {
+		return slices.Contains(channelIds, event.Destination)
+	}
+}
+
+// # Match Configured Channels
+//
+// Match events received on a channel present in the configuration.
+func MatchConfiguredChannels() EventMatcher {
+	return func(bot *TaipeionBot, event ChatbotWebhookEvent) bool {
+		_, ok := bot.Channels[event.Destination]
+		return ok
+	}
+}
+
+// # Match Event Types
+func MatchEventTypes(eventTypes ...tp.EventType) EventMatcher {
+	return func(bot *TaipeionBot, event ChatbotWebhookEvent) bool {
+		return slices.Contains(eventTypes, event.Type)
+	}
+}
+
+// # Match Message Types
+//
+// Match message events whose message is of one of the types, see `tp.MessageType*`.
+func MatchMessageTypes(messageTypes ...string) EventMatcher {
+	return func(bot *TaipeionBot, event ChatbotWebhookEvent) bool {
+		return event.Type == tp.EventTypeMessage && slices.Contains(messageTypes, event.Message.Type)
+	}
+}
+
+// # Match Text Prefix
+//
+// Match text messages starting with the prefix, leading spaces are ignored.
+func MatchPrefix(prefix string) EventMatcher {
+	return func(bot *TaipeionBot, event ChatbotWebhookEvent) bool {
+		return event.Message.Type == tp.MessageTypeText && strings.HasPrefix(strings.TrimSpace(event.Message.Text), prefix)
+	}
+}
+
+// # Match Channel Trigger Word
+//
+// Match text messages starting with the trigger word of the channel they are received on.
+// Events of unconfigured channels never match.
+func MatchChannelTriggerWord() EventMatcher {
+	return func(bot *TaipeionBot, event ChatbotWebhookEvent) bool {
+		channel, ok := bot.Channels[event.Destination]
+		return ok && MatchPrefix(channel.ChannelTriggerPrefix)(bot, event)
+	}
+}
+
+// # Match Regular Expression
+//
+// Match text messages matching the pattern.
+// The pattern is compiled at once, and it panics if the pattern is invalid.
+func MatchRegex(pattern string) EventMatcher {
+	re := regexp.MustCompile(pattern)
+	return func(bot *TaipeionBot, event ChatbotWebhookEvent) bool {
+		return event.Message.Type == tp.MessageTypeText && re.MatchString(event.Message.Text)
+	}
+}
+
+// # Match Users
+//
+// Match events from one of the users.
+func MatchUsers(userIds ...string) EventMatcher {
+	users := make(map[string]struct{}, len(userIds))
+	for _, id := range userIds {
+		users[id] = struct{}{}
+	}
+	return func(bot *TaipeionBot, event ChatbotWebhookEvent) bool {
+		_, ok := users[event.Source.UserId]
+		return ok
+	}
+}
+
+// # Match All
+//
+// Match if all matchers hold, or if there is no matcher.
+func MatchAll(matchers ...EventMatcher) EventMatcher {
+	return func(bot *TaipeionBot, event ChatbotWebhookEvent) bool {
+		for _, matcher := range matchers {
+			if !matcher(bot, event) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// # Match Any
+//
+// Match if at least one matcher holds.
+func MatchAny(matchers ...EventMatcher) EventMatcher {
+	return func(bot *TaipeionBot, event ChatbotWebhookEvent) bool {
+		for _, matcher := range matchers {
+			if matcher(bot, event) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// # Match Not
+func MatchNot(matcher EventMatcher) EventMatcher {
+	return func(bot *TaipeionBot, event ChatbotWebhookEvent) bool {
+		return !matcher(bot, event)
+	}
+}
+
+// # Route Registration
+//
+// Register a handler called only on events matching all the matchers.
+func (tpb *TaipeionBot) Route(ev_handler_entry eventHandlerEntry, matchers ...EventMatcher) {
+	tpb.RegisterWebhookEventCallback(ev_handler_entry.When(matchers...))
+}
+
+// # Set Routing Mode
+//
+// Choose between fan-out (default) and first-match dispatching.
+func (tpb *TaipeionBot) SetRoutingMode(mode RoutingMode) {
+	tpb.routingMode = mode
+}
+
+// # Route an Event
+//
+// Select the handlers applying to the event according to the routing mode.
+func (tpb *TaipeionBot) routeEvent(event ChatbotWebhookEvent) []eventHandlerEntry {
+	handlers := make([]eventHandlerEntry, 0, len(tpb.eventHandlers))
+	for _, event_handler := range tpb.eventHandlers {
+		if !event_handler.matches(tpb, event) {
+			continue
+		}
+
+		handlers = append(handlers, event_handler)
+		if tpb.routingMode == RouteFirstMatch {
+			break
+		}
+	}
+	return handlers
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	tp "taipeion/core"
+)
+
+func TestEventMatchers(t *testing.T) {
+	bot := NewChatbotInstance("", map[int]Channel{1: {ChannelTriggerPrefix: "Hello"}}, "", 0, "", "", "", 1)
+
+	event := textEvent(1, "  Hello, what time is it?")
+	event.Source.UserId = "user-1"
+
+	cases := map[string]struct {
+		matcher  EventMatcher
+		expected bool
+	}{
+		"channel":          {MatchChannels(1, 3), true},
+		"other channel":    {MatchChannels(2), false},
+		"event type":       {MatchEventTypes(tp.EventTypeMessage), true},
+		"follow":           {MatchEventTypes(tp.EventTypeFollow), false},
+		"message type":     {MatchMessageTypes(tp.MessageTypeImage), false},
+		"trigger word":     {MatchChannelTriggerWord(), true},
+		"regex":            {MatchRegex(`time\s+is`), true},
+		"users":            {MatchUsers("user-2"), false},
+		"all":              {MatchAll(MatchChannels(1), MatchPrefix("Hello")), true},
+		"any":              {MatchAny(MatchChannels(2), MatchPrefix("Bye")), false},
+		"not":              {MatchNot(MatchUsers("user-2")), true},
+		"empty all":        {MatchAll(), true},
+		"configured":       {MatchConfiguredChannels(), true},
+		"unconfigured key": {MatchAll(MatchChannelTriggerWord(), MatchChannels(9)), false},
+	}
+
+	for name, c := range cases {
+		if c.matcher(bot, event) != c.expected {
+			t.Errorf("%s: expected %v", name, c.expected)
+		}
+	}
+}
+
+func TestRoutingModes(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	noop := func(bot *TaipeionBot, event ChatbotWebhookEvent) error { return nil }
+
+	bot.Route(ScheduleCallbackNormalPriority(noop), MatchPrefix("/admin"))
+	bot.Route(ScheduleCallbackNormalPriority(noop), MatchMessageTypes(tp.MessageTypeText))
+	bot.Route(ScheduleCallbackNormalPriority(noop))
+
+	if handlers := bot.routeEvent(textEvent(1, "/admin stats")); len(handlers) != 3 {
+		t.Errorf("Fan-out: expected 3 handlers, got %d", len(handlers))
+	}
+	if handlers := bot.routeEvent(textEvent(1, "hi")); len(handlers) != 2 {
+		t.Errorf("Fan-out: expected 2 handlers, got %d", len(handlers))
+	}
+
+	bot.SetRoutingMode(RouteFirstMatch)
+	if handlers := bot.routeEvent(textEvent(1, "hi")); len(handlers) != 1 {
+		t.Errorf("First-match: expected 1 handler, got %d", len(handlers))
+	}
+}
+
+func TestUnknownRoutingMode(t *testing.T) {
+	if _, err := NewChatbotFromConfig(ServerConfig{RoutingMode: "first_match"}); err == nil {
+		t.Errorf("Expected an unknown routing mode to be rejected")
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Built-in priority classes.
+const (
+	PriorityHighest = "highest" // Bypasses the concurrency limit, for quick non-blocking handlers.
+	PriorityHigh    = "high"    // Admin commands, welcome messages.
+	PriorityNormal  = "normal"  // Default, e.g. LLM questions.
+	PriorityLow     = "low"     // Background work.
+)
+
+// # Priority Class Configuration
+//
+// Handlers of a class with higher priority always start before queued handlers of lower classes.
+// Classes with the same priority share the free slots according to their weights.
+type PriorityClassConfig struct {
+	Name              string `yaml:"name"`                // Name of the class, referred to by handlers.
+	Priority          int    `yaml:"priority"`            // Higher runs first.
+	MaxConcurrent     int    `yaml:"max-concurrent"`      // Maximum number of running handlers of the class, unlimited if zero.
+	Weight            int    `yaml:"weight"`              // Share of the slots among classes with the same priority, defaults to 1.
+	BypassGlobalLimit bool   `yaml:"bypass-global-limit"` // Do not count against `max-concurrent-event-handlers`.
+}
+
+// The built-in classes, overridden by configured classes of the same name.
+func defaultPriorityClasses() []PriorityClassConfig {
+	return []PriorityClassConfig{
+		{Name: PriorityHighest, Priority: 100, BypassGlobalLimit: true},
+		{Name: PriorityHigh, Priority: 50},
+		{Name: PriorityNormal, Priority: 0},
+		{Name: PriorityLow, Priority: -50},
+	}
+}
+
+type schedulerWaiter struct {
+	class   *priorityClass
+	queue   *channelQueue
+	ready   chan struct{} // Closed once the slot is granted.
+	granted bool
+	left    bool // Stopped waiting, the context is done.
+}
+
+// The waiters of a class from one channel.
+type channelQueue struct {
+	channel int
+	waiting *list.List // Waiters in arrival order.
+	current int        // Credit of the smooth weighted round-robin.
+}
+
+type priorityClass struct {
+	PriorityClassConfig
+	running int
+	waiting int             // Waiters of every channel.
+	queues  []*channelQueue // Queues by channel, in channel order.
+	current int             // Credit of the smooth weighted round-robin.
+}
+
+// Get the queue of the channel, created if needed.
+func (c *priorityClass) queue(channel int) *channelQueue {
+	index, found := sort.Find(len(c.queues), func(i int) int { return channel - c.queues[i].channel })
+	if !found {
+		c.queues = slices.Insert(c.queues, index, &channelQueue{channel: channel, waiting: list.New()})
+	}
+	return c.queues[index]
+}
+
+// # Priority Scheduler
+//
+// Grant handler slots by priority class and channel.
+// A slot is granted to the waiter of the highest priority class which is below its own limit
+// and, unless it bypasses it, below the global limit and the limit of the channel.
+// Classes of the same priority are served with smooth weighted round-robin, so are the channels
+// within a class, and waiters of the same class and channel in arrival order.
+type priorityScheduler struct {
+	mu             sync.Mutex
+	capacity       int // Global limit of running handlers.
+	running        int // Running handlers counting against the global limit.
+	classes        map[string]*priorityClass
+	levels         [][]*priorityClass // Classes grouped by priority, the highest first.
+	channelLimits  map[int]int        // Running handlers allowed per channel, unlimited if absent.
+	channelWeights map[int]int        // Share of the slots per channel, 1 if absent.
+	channelRunning map[int]int        // Running handlers per channel, counting against the global limit.
+	channelWaiting map[int]int        // Waiters per channel.
+	metrics        *MetricsRegistry
+}
+
+// # New Priority Scheduler
+//
+// Create a scheduler with the built-in classes, overridden or extended by the configured ones,
+// and the limits and weights of the channels.
+func newPriorityScheduler(capacity int, configs []PriorityClassConfig, channels map[int]Channel, metrics *MetricsRegistry) *priorityScheduler {
+	s := &priorityScheduler{
+		capacity:       capacity,
+		classes:        make(map[string]*priorityClass),
+		channelLimits:  make(map[int]int),
+		channelWeights: make(map[int]int),
+		channelRunning: make(map[int]int),
+		channelWaiting: make(map[int]int),
+		metrics:        metrics,
+	}
+	if s.capacity <= 0 {
+		s.capacity = 1
+	}
+
+	for channel_id, channel := range channels {
+		if channel.MaxConcurrentHandlers > 0 {
+			s.channelLimits[channel_id] = channel.MaxConcurrentHandlers
+		}
+		if channel.SchedulingWeight > 0 {
+			s.channelWeights[channel_id] = channel.SchedulingWeight
+		}
+	}
+
+	for _, config := range append(defaultPriorityClasses(), configs...) {
+		if config.Name == "" {
+			log.Println("[Init] Error: Priority class without name, ignored.")
+			continue
+		}
+		if config.Weight <= 0 {
+			config.Weight = 1
+		}
+		s.classes[config.Name] = &priorityClass{PriorityClassConfig: config}
+	}
+
+	// Group the classes by priority.
+	by_priority := make(map[int][]*priorityClass)
+	for _, class := range s.classes {
+		by_priority[class.Priority] = append(by_priority[class.Priority], class)
+	}
+	for _, classes := range by_priority {
+		sort.Slice(classes, func(i, j int) bool { return classes[i].Name < classes[j].Name })
+		s.levels = append(s.levels, classes)
+	}
+	sort.Slice(s.levels, func(i, j int) bool { return s.levels[i][0].Priority > s.levels[j][0].Priority })
+
+	return s
+}
+
+// Whether the class exists, an empty name refers to the normal class.
+func (s *priorityScheduler) hasClass(name string) bool {
+	_, ok := s.classes[name]
+	return ok || name == ""
+}
+
+// Get a class by name, the normal class if empty.
+// Handlers of unknown classes are refused at registration.
+func (s *priorityScheduler) class(name string) *priorityClass {
+	if class, ok := s.classes[name]; ok {
+		return class
+	}
+	return s.classes[PriorityNormal]
+}
+
+// # Acquire a Slot
+//
+// Wait for a slot of the class on the channel, returns the function releasing it.
+// Returns the context error if the context is done before the slot is granted.
+func (s *priorityScheduler) acquire(ctx context.Context, className string, channel int) (func(), error) {
+	return s.acquireWithUpdates(ctx, className, channel, 0, nil)
+}
+
+// # Acquire a Slot with Position Updates
+//
+// Same as `acquire`, and if the slot is not granted immediately, call `notify` with the position
+// of the waiter and the number of slots serving it, then again every `interval` if positive.
+// `notify` is called from a goroutine of the waiter, one call at a time, so that a slow notice never
+// holds a granted slot. Notices due once the slot is granted or the wait is abandoned are dropped.
+func (s *priorityScheduler) acquireWithUpdates(ctx context.Context, className string, channel int, interval time.Duration, notify func(position int, slots int)) (func(), error) {
+	s.mu.Lock()
+	class := s.class(className)
+	waiter := &schedulerWaiter{class: class, queue: class.queue(channel), ready: make(chan struct{})}
+	element := waiter.queue.waiting.PushBack(waiter)
+	class.waiting++
+	s.channelWaiting[channel]++
+	s.grantLocked()
+	s.updateMetricsLocked(class, channel)
+
+	var updates chan struct{} // Pending notice, at most one.
+	if notify != nil && !waiter.granted {
+		updates = make(chan struct{}, 1)
+		updates <- struct{}{} // Report the position, unless granted right away.
+		defer close(updates)
+
+		go func() {
+			for range updates {
+				s.mu.Lock()
+				if waiter.granted || waiter.left {
+					s.mu.Unlock()
+					return
+				}
+				position, slots := s.positionLocked(waiter, element), s.slotsLocked(class, channel)
+				s.mu.Unlock()
+				notify(position, slots)
+			}
+		}()
+	}
+	s.mu.Unlock()
+
+	var ticks <-chan time.Time
+	if updates != nil && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case <-waiter.ready:
+			return s.releaseFunc(class, channel), nil
+
+		case <-ticks:
+			select {
+			case updates <- struct{}{}:
+			default: // The previous notice is still being sent.
+			}
+
+		case <-ctx.Done():
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if waiter.granted { // Granted in the meantime, give it back.
+				s.releaseLocked(class, channel)
+			} else {
+				waiter.queue.waiting.Remove(element)
+				waiter.left = true
+				class.waiting--
+				s.channelWaiting[channel]--
+				s.updateMetricsLocked(class, channel)
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Estimate the position of a waiter from the scheduling order: the waiters ahead in its queue,
+// the waiters of the other channels served in turn meanwhile, and the waiters of higher priority classes.
+// Later arrivals of higher priority may still get ahead.
+func (s *priorityScheduler) positionLocked(waiter *schedulerWaiter, element *list.Element) int {
+	ahead := 0
+	for e := waiter.queue.waiting.Front(); e != nil && e != element; e = e.Next() {
+		ahead++
+	}
+	position := ahead + 1
+
+	// The other channels of the class are served in turn, according to their weights.
+	weight := max(s.channelWeights[waiter.queue.channel], 1)
+	for _, queue := range waiter.class.queues {
+		if queue == waiter.queue {
+			continue
+		}
+		turns := ((ahead+1)*max(s.channelWeights[queue.channel], 1) + weight - 1) / weight
+		position += min(queue.waiting.Len(), turns)
+	}
+
+	// Higher priority classes are served first.
+	for _, level := range s.levels {
+		if level[0].Priority <= waiter.class.Priority {
+			break
+		}
+		for _, class := range level {
+			if !class.BypassGlobalLimit {
+				position += class.waiting
+			}
+		}
+	}
+	return position
+}
+
+// Number of slots the waiters of the class on the channel are served with.
+func (s *priorityScheduler) slotsLocked(class *priorityClass, channel int) int {
+	slots := s.capacity
+	if class.MaxConcurrent > 0 {
+		slots = min(slots, class.MaxConcurrent)
+	}
+	if limit, ok := s.channelLimits[channel]; ok && !class.BypassGlobalLimit {
+		slots = min(slots, limit)
+	}
+	return slots
+}
+
+func (s *priorityScheduler) releaseFunc(class *priorityClass, channel int) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.releaseLocked(class, channel)
+		})
+	}
+}
+
+func (s *priorityScheduler) releaseLocked(class *priorityClass, channel int) {
+	class.running--
+	if !class.BypassGlobalLimit {
+		s.running--
+		s.channelRunning[channel]--
+	}
+	s.updateMetricsLocked(class, channel)
+	s.grantLocked()
+}
+
+// Whether a waiter of the class on the channel can be granted a slot now.
+func (s *priorityScheduler) eligibleLocked(class *priorityClass, queue *channelQueue) bool {
+	if queue.waiting.Len() == 0 {
+		return false
+	}
+	if class.MaxConcurrent > 0 && class.running >= class.MaxConcurrent {
+		return false
+	}
+	if class.BypassGlobalLimit {
+		return true
+	}
+	if limit, ok := s.channelLimits[queue.channel]; ok && s.channelRunning[queue.channel] >= limit {
+		return false
+	}
+	return s.running < s.capacity
+}
+
+// Pick the queue of the class served next with smooth weighted round-robin among the eligible channels,
+// nil if none is eligible. The credits are only updated if `commit` is set.
+func (s *priorityScheduler) nextQueueLocked(class *priorityClass, commit bool) *channelQueue {
+	var picked *channelQueue
+	total := 0
+	for _, queue := range class.queues {
+		if !s.eligibleLocked(class, queue) {
+			continue
+		}
+		weight := max(s.channelWeights[queue.channel], 1)
+		total += weight
+		if picked == nil || queue.current+weight > picked.current+max(s.channelWeights[picked.channel], 1) {
+			picked = queue
+		}
+	}
+	if picked == nil || !commit {
+		return picked
+	}
+
+	for _, queue := range class.queues {
+		if s.eligibleLocked(class, queue) {
+			queue.current += max(s.channelWeights[queue.channel], 1)
+		}
+	}
+	picked.current -= total
+	return picked
+}
+
+// Grant slots to waiters until no waiter is eligible.
+func (s *priorityScheduler) grantLocked() {
+	for {
+		class := s.nextClassLocked()
+		if class == nil {
+			return
+		}
+		queue := s.nextQueueLocked(class, true)
+
+		waiter := queue.waiting.Remove(queue.waiting.Front()).(*schedulerWaiter)
+		waiter.granted = true
+		class.waiting--
+		s.channelWaiting[queue.channel]--
+		class.running++
+		if !class.BypassGlobalLimit {
+			s.running++
+			s.channelRunning[queue.channel]++
+		}
+		close(waiter.ready)
+		s.updateMetricsLocked(class, queue.channel)
+	}
+}
+
+// Pick the class served next: the highest priority level with an eligible class,
+// then smooth weighted round-robin among the eligible classes of the level.
+func (s *priorityScheduler) nextClassLocked() *priorityClass {
+	for _, level := range s.levels {
+		var picked *priorityClass
+		total := 0
+		for _, class := range level {
+			if s.nextQueueLocked(class, false) == nil {
+				continue
+			}
+			class.current += class.Weight
+			total += class.Weight
+			if picked == nil || class.current > picked.current {
+				picked = class
+			}
+		}
+		if picked != nil {
+			picked.current -= total
+			return picked
+		}
+	}
+	return nil
+}
+
+func (s *priorityScheduler) updateMetricsLocked(class *priorityClass, channel int) {
+	if s.metrics == nil {
+		return
+	}
+	s.metrics.Set(fmt.Sprintf("scheduler_%s_running", class.Name), int64(class.running))
+	s.metrics.Set(fmt.Sprintf("scheduler_%s_waiting", class.Name), int64(class.waiting))
+	s.metrics.Set(fmt.Sprintf("scheduler_channel_%d_running", channel), int64(s.channelRunning[channel]))
+	s.metrics.Set(fmt.Sprintf("scheduler_channel_%d_waiting", channel), int64(s.channelWaiting[channel]))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Acquire a slot in the background, recording the class once granted.
+func acquireInBackground(s *priorityScheduler, class string, order *[]string, mu *sync.Mutex) {
+	go func() {
+		release, err := s.acquire(context.Background(), class, 1)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		*order = append(*order, class)
+		mu.Unlock()
+		release()
+	}()
+}
+
+// Wait until the number of waiters of the class reaches `n`.
+func waitForWaiters(t *testing.T, s *priorityScheduler, class string, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		waiting := s.classes[class].waiting
+		s.mu.Unlock()
+		if waiting == n {
+			return
+		}
+	}
+	t.Fatalf("Timed out waiting for %d waiters of class %s", n, class)
+}
+
+func TestSchedulerPriorityOrder(t *testing.T) {
+	s := newPriorityScheduler(1, nil, nil, NewMetricsRegistry())
+
+	release, err := s.acquire(context.Background(), PriorityNormal, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	acquireInBackground(s, PriorityLow, &order, &mu)
+	waitForWaiters(t, s, PriorityLow, 1)
+	acquireInBackground(s, PriorityNormal, &order, &mu)
+	waitForWaiters(t, s, PriorityNormal, 1)
+	acquireInBackground(s, PriorityHigh, &order, &mu)
+	waitForWaiters(t, s, PriorityHigh, 1)
+
+	// The highest class bypasses the global limit.
+	if release_highest, err := s.acquire(context.Background(), PriorityHighest, 1); err != nil {
+		t.Fatal(err)
+	} else {
+		release_highest()
+	}
+
+	release()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		mu.Lock()
+		done := len(order) == 3
+		mu.Unlock()
+		if done {
+			break
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if expected := []string{PriorityHigh, PriorityNormal, PriorityLow}; !slices.Equal(order, expected) {
+		t.Errorf("Expected %v, got %v", expected, order)
+	}
+}
+
+func TestSchedulerClassLimitAndCancel(t *testing.T) {
+	s := newPriorityScheduler(10, []PriorityClassConfig{{Name: "llm", MaxConcurrent: 1}}, nil, NewMetricsRegistry())
+
+	release, err := s.acquire(context.Background(), "llm", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The class is full, other classes are not affected.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(ctx, "llm", 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the class limit to apply, got %v", err)
+	}
+	if release_normal, err := s.acquire(context.Background(), PriorityNormal, 1); err != nil {
+		t.Errorf("Expected other classes to run, got %v", err)
+	} else {
+		release_normal()
+	}
+
+	release()
+	if s.classes["llm"].waiting != 0 || s.running != 0 {
+		t.Errorf("Expected the cancelled waiter to be removed and the slots released")
+	}
+}
+
+func TestSchedulerWeightedShare(t *testing.T) {
+	s := newPriorityScheduler(1, []PriorityClassConfig{
+		{Name: "a", Priority: 10, Weight: 3},
+		{Name: "b", Priority: 10, Weight: 1},
+	}, nil, NewMetricsRegistry())
+
+	release, _ := s.acquire(context.Background(), PriorityNormal, 1)
+
+	var mu sync.Mutex
+	var order []string
+	for i := 0; i < 4; i++ {
+		acquireInBackground(s, "a", &order, &mu)
+		acquireInBackground(s, "b", &order, &mu)
+	}
+	waitForWaiters(t, s, "a", 4)
+	waitForWaiters(t, s, "b", 4)
+
+	release()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		mu.Lock()
+		done := len(order) == 8
+		mu.Unlock()
+		if done {
+			break
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != 8 || slices.Index(order[:4], "b") < 0 || slices.Index(order[:4], "a") < 0 {
+		t.Errorf("Expected the classes to share the slots, got %v", order)
+	}
+	if a := len(slices.DeleteFunc(slices.Clone(order[:4]), func(c string) bool { return c != "a" })); a != 3 {
+		t.Errorf("Expected 3 of the first 4 slots for class a, got %d in %v", a, order)
+	}
+}
+
+func TestUnknownPriorityClass(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	noop := func(bot *TaipeionBot, event ChatbotWebhookEvent) error { return nil }
+
+	bot.RegisterWebhookEventCallback(ScheduleCallbackWithPriority(noop, "urgent"))
+	if len(bot.eventHandlers) != 0 {
+		t.Errorf("Expected the handler of an unknown class not to be registered")
+	}
+	if err := bot.Start(); err == nil {
+		t.Errorf("Expected the chatbot to refuse to start")
+	}
+}
+
+func TestSchedulerChannelFairness(t *testing.T) {
+	channels := map[int]Channel{1: {}, 2: {MaxConcurrentHandlers: 1}}
+	s := newPriorityScheduler(2, nil, channels, NewMetricsRegistry())
+
+	// The limit of channel 2 leaves a slot for channel 1.
+	release_2, _ := s.acquire(context.Background(), PriorityNormal, 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(ctx, PriorityNormal, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the channel limit to apply, got %v", err)
+	}
+	release_1, err := s.acquire(context.Background(), PriorityNormal, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A busy channel does not starve the others.
+	var mu sync.Mutex
+	var order []int
+	acquire := func(channel int) {
+		go func() {
+			release, err := s.acquire(context.Background(), PriorityNormal, channel)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			order = append(order, channel)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			release()
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		acquire(1)
+		waitForWaiters(t, s, PriorityNormal, i+1)
+	}
+	acquire(3)
+	waitForWaiters(t, s, PriorityNormal, 4)
+	if s.metrics.Get("scheduler_channel_1_waiting") != 3 {
+		t.Errorf("Expected the queue depth of channel 1 in metrics, got %v", s.metrics.Snapshot())
+	}
+
+	release_2()
+	release_1()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		mu.Lock()
+		done := len(order) == 4
+		mu.Unlock()
+		if done {
+			break
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != 4 || slices.Index(order, 3) > 1 {
+		t.Errorf("Expected channel 3 to be served among the first two, got %v", order)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	tp "taipeion/core"
+)
+
+// Serialization keys, events of the same key are handled one at a time by each handler.
+const (
+	SerializeNone    = "none"    // Handle every event concurrently.
+	SerializeUser    = "user"    // One event per user and channel at a time.
+	SerializeChannel = "channel" // One event per channel at a time.
+)
+
+// What happens to a new event while an event of the same key is in flight.
+const (
+	InFlightQueue   = "queue"   // Wait for the events before it, in order.
+	InFlightReplace = "replace" // Cancel the event in flight and the waiting ones, then handle the new one.
+	InFlightMerge   = "merge"   // Merge the text of the waiting messages into a single event.
+	InFlightReject  = "reject"  // Discard the new event and tell the user.
+
+	defaultInFlightRejectMessage = "您的上一個問題仍在處理中，請稍候。"
+)
+
+// The handler call was cancelled because a newer event of the same key replaced it.
+var ErrEventReplaced = errors.New("replaced by a newer event")
+
+// # Serialization Configuration
+type SerializationConfig struct {
+	Key           string `yaml:"key"`            // "user" (default), "channel" or "none".
+	Policy        string `yaml:"policy"`         // "queue" (default), "replace", "merge" or "reject".
+	RejectMessage string `yaml:"reject-message"` // Reply of the "reject" policy.
+}
+
+// Fill zero values of the serialization configuration with defaults.
+func newSerializationConfig(config SerializationConfig) (SerializationConfig, error) {
+	if err := validateSerialization(config.Key, config.Policy); err != nil {
+		return config, err
+	}
+
+	if config.Key == "" {
+		config.Key = SerializeUser
+	}
+	if config.Policy == "" {
+		config.Policy = InFlightQueue
+	}
+	if config.RejectMessage == "" {
+		config.RejectMessage = defaultInFlightRejectMessage
+	}
+	return config, nil
+}
+
+// Check the serialization key and the in-flight policy, empty values are valid.
+func validateSerialization(key string, policy string) error {
+	switch key {
+	case "", SerializeNone, SerializeUser, SerializeChannel:
+	default:
+		return fmt.Errorf("unknown serialization key: %q", key)
+	}
+
+	switch policy {
+	case "", InFlightQueue, InFlightReplace, InFlightMerge, InFlightReject:
+	default:
+		return fmt.Errorf("unknown in-flight policy: %q", policy)
+	}
+	return nil
+}
+
+// An event waiting in, or running on, a lane.
+type laneTicket struct {
+	event   ChatbotWebhookEvent
+	ctx     context.Context // Set once it is the ticket's turn.
+	turn    chan struct{}   // Closed when it is the ticket's turn, or when it is dropped.
+	done    chan struct{}   // Closed when the ticket is handled or dropped.
+	dropped bool
+}
+
+func newLaneTicket(event ChatbotWebhookEvent) *laneTicket {
+	return &laneTicket{event: event, turn: make(chan struct{}), done: make(chan struct{})}
+}
+
+// The events of a serialization key.
+type serialLane struct {
+	running *laneTicket
+	cancel  context.CancelCauseFunc // Cancel the running ticket.
+	pending []*laneTicket
+}
+
+// # Serial Lanes
+//
+// Keep the events of each (handler, key) in order.
+type serialLanes struct {
+	mu    sync.Mutex
+	lanes map[string]*serialLane
+}
+
+func newSerialLanes() *serialLanes {
+	return &serialLanes{lanes: make(map[string]*serialLane)}
+}
+
+// # Serialize Handler
+//
+// Return a copy of the entry serialized by the key, with the policy for events arriving
+// while another one is in flight. Empty values fall back to the server configuration.
+func (entry eventHandlerEntry) SerializeBy(key string, policy string) eventHandlerEntry {
+	entry.SerialKey = key
+	entry.InFlightPolicy = policy
+	return entry
+}
+
+// Get the lane key of the event for the handler, empty if the event is not serialized.
+// Handlers are told apart by registration index, since several of them may share a name.
+func (tpb *TaipeionBot) serialKey(entry eventHandlerEntry, event ChatbotWebhookEvent) string {
+	key := entry.SerialKey
+	if key == "" {
+		key = tpb.serialization.Key
+	}
+
+	switch key {
+	case SerializeUser:
+		if event.Source.UserId == "" {
+			return ""
+		}
+		return fmt.Sprintf("%d|%d|%s", entry.index, event.Destination, event.Source.UserId)
+	case SerializeChannel:
+		return fmt.Sprintf("%d|%d", entry.index, event.Destination)
+	default:
+		return ""
+	}
+}
+
+// Whether the text of the event can be merged into the waiting one.
+func mergeableEvents(waiting ChatbotWebhookEvent, event ChatbotWebhookEvent) bool {
+	return waiting.Type == tp.EventTypeMessage && event.Type == tp.EventTypeMessage &&
+		waiting.Message.Type == tp.MessageTypeText && event.Message.Type == tp.MessageTypeText
+}
+
+// # Run Serialized
+//
+// Call the handler once the events of the same key before it are handled, applying the
+// in-flight policy of the handler. Returns once the event is handled, merged into a handled
+// event, rejected or dropped.
+func (tpb *TaipeionBot) runSerialized(entry eventHandlerEntry, event ChatbotWebhookEvent) {
+	key := tpb.serialKey(entry, event)
+	if key == "" {
+		tpb.executeEventHandler(tpb.handlersCtx, entry, event, "")
+		return
+	}
+
+	policy := entry.InFlightPolicy
+	if policy == "" {
+		policy = tpb.serialization.Policy
+	}
+
+	lanes := tpb.serialLanes
+	lanes.mu.Lock()
+
+	lane, ok := lanes.lanes[key]
+	if !ok {
+		lane = &serialLane{}
+		lanes.lanes[key] = lane
+	}
+
+	ticket := newLaneTicket(event)
+	if lane.running == nil {
+		tpb.startTicketLocked(lane, ticket)
+		lanes.mu.Unlock()
+		tpb.runTicket(key, lane, entry, ticket)
+		return
+	}
+
+	switch policy {
+	case InFlightReject:
+		lanes.mu.Unlock()
+		log.Printf("[EvProcessor] Rejecting event from user (%s) on channel (%d), handler (%s) is busy.\n", event.Source.UserId, event.Destination, entry.Name)
+		tpb.Metrics.Inc("serial_rejected_total")
+		if event.Type == tp.EventTypeMessage {
+			if err := tpb.SendPrivateMessageContext(tpb.handlersCtx, event.Source.UserId, tpb.serialization.RejectMessage, event.Destination); err != nil {
+				log.Println("[EvProcessor] Error: Unable to send the rejection notice:", err)
+			}
+		}
+		return
+
+	case InFlightMerge:
+		if n := len(lane.pending); n > 0 && mergeableEvents(lane.pending[n-1].event, event) {
+			waiting := lane.pending[n-1]
+			waiting.event.Message.Text += "\n" + event.Message.Text
+			lanes.mu.Unlock()
+			tpb.Metrics.Inc("serial_merged_total")
+			<-waiting.done // Acknowledged along with the event it was merged into.
+			return
+		}
+
+	case InFlightReplace:
+		lane.cancel(ErrEventReplaced)
+		for _, waiting := range lane.pending {
+			waiting.dropped = true
+			close(waiting.turn)
+			close(waiting.done)
+		}
+		tpb.Metrics.Add("serial_replaced_total", int64(len(lane.pending))+1)
+		lane.pending = nil
+	}
+
+	lane.pending = append(lane.pending, ticket)
+	tpb.Metrics.Inc("serial_queued_total")
+	lanes.mu.Unlock()
+
+	<-ticket.turn
+	if ticket.dropped {
+		return
+	}
+	tpb.runTicket(key, lane, entry, ticket)
+}
+
+// Make the ticket the running one of the lane.
+func (tpb *TaipeionBot) startTicketLocked(lane *serialLane, ticket *laneTicket) {
+	ticket.ctx, lane.cancel = context.WithCancelCause(tpb.handlersCtx)
+	lane.running = ticket
+}
+
+// Handle the ticket, then hand the lane over to the next waiting ticket.
+func (tpb *TaipeionBot) runTicket(key string, lane *serialLane, entry eventHandlerEntry, ticket *laneTicket) {
+	lanes := tpb.serialLanes
+
+	// The merged text is final once the ticket leaves the pending list.
+	lanes.mu.Lock()
+	event := ticket.event
+	lanes.mu.Unlock()
+
+	tpb.executeEventHandler(ticket.ctx, entry, event, "")
+
+	lanes.mu.Lock()
+	defer lanes.mu.Unlock()
+
+	lane.cancel(nil)
+	close(ticket.done)
+
+	if len(lane.pending) == 0 {
+		lane.running = nil
+		delete(lanes.lanes, key)
+		return
+	}
+	next := lane.pending[0]
+	lane.pending = lane.pending[1:]
+	tpb.startTicketLocked(lane, next)
+	close(next.turn)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run the events through a serialized handler, the calls are held until every event reached the lane.
+func runSerializedEvents(t *testing.T, policy string, texts ...string) (*TaipeionBot, []string) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	bot.retry.MaxAttempts = 1
+
+	var mu sync.Mutex
+	var handled []string
+	started := make(chan struct{})
+	release := make(chan struct{})
+	entry := ScheduleContextCallbackHighestPriority(func(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent) error {
+		if event.Message.Text == texts[0] {
+			close(started)
+		}
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		mu.Lock()
+		handled = append(handled, event.Message.Text)
+		mu.Unlock()
+		return nil
+	}).Named("echo").SerializeBy(SerializeUser, policy)
+
+	event := func(text string) ChatbotWebhookEvent {
+		event := textEvent(1, text)
+		event.Source.UserId = "citizen"
+		return event
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		bot.runSerialized(entry, event(texts[0]))
+	}()
+	<-started
+
+	for i, text := range texts[1:] {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bot.runSerialized(entry, event(text))
+		}()
+		waitForSerialized(t, bot, int64(i+1))
+	}
+
+	close(release)
+	wg.Wait()
+	return bot, handled
+}
+
+// Wait until the number of events which reached a busy lane.
+func waitForSerialized(t *testing.T, bot *TaipeionBot, n int64) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		count := bot.Metrics.Get("serial_queued_total") + bot.Metrics.Get("serial_merged_total") + bot.Metrics.Get("serial_rejected_total")
+		if count >= n {
+			return
+		}
+	}
+	t.Fatalf("Timed out waiting for %d events to reach the lane", n)
+}
+
+func TestSerializedQueue(t *testing.T) {
+	_, handled := runSerializedEvents(t, InFlightQueue, "1", "2", "3")
+	if expected := []string{"1", "2", "3"}; !slices.Equal(handled, expected) {
+		t.Errorf("Expected %v, got %v", expected, handled)
+	}
+}
+
+func TestSerializedMerge(t *testing.T) {
+	_, handled := runSerializedEvents(t, InFlightMerge, "1", "2", "3")
+	if expected := []string{"1", "2\n3"}; !slices.Equal(handled, expected) {
+		t.Errorf("Expected %v, got %v", expected, handled)
+	}
+}
+
+func TestSerializedReplace(t *testing.T) {
+	bot, handled := runSerializedEvents(t, InFlightReplace, "1", "2", "3")
+	if expected := []string{"3"}; !slices.Equal(handled, expected) {
+		t.Errorf("Expected %v, got %v", expected, handled)
+	}
+	if len(bot.DeadLetters()) != 0 {
+		t.Errorf("Expected replaced events not to be parked")
+	}
+}
+
+func TestSerializedReject(t *testing.T) {
+	bot, handled := runSerializedEvents(t, InFlightReject, "1", "2")
+	if expected := []string{"1"}; !slices.Equal(handled, expected) {
+		t.Errorf("Expected %v, got %v", expected, handled)
+	}
+	if bot.Metrics.Get("serial_rejected_total") != 1 {
+		t.Errorf("Expected the rejection to be counted")
+	}
+}
+
+func TestSerializedLanesPerHandler(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	noop := func(bot *TaipeionBot, event ChatbotWebhookEvent) error { return nil }
+	bot.RegisterWebhookEventCallback(ScheduleCallbackNormalPriority(noop)) // Unnamed, both derive the same name.
+	bot.RegisterWebhookEventCallback(ScheduleCallbackNormalPriority(noop))
+
+	event := textEvent(1, "hello")
+	event.Source.UserId = "citizen"
+	if bot.serialKey(bot.eventHandlers[0], event) == bot.serialKey(bot.eventHandlers[1], event) {
+		t.Errorf("Expected handlers sharing a name to have their own lanes")
+	}
+}
+
+func TestInvalidSerialization(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	noop := func(bot *TaipeionBot, event ChatbotWebhookEvent) error { return nil }
+
+	bot.RegisterWebhookEventCallback(ScheduleCallbackNormalPriority(noop).Named("echo"))
+	bot.RegisterWebhookEventCallback(ScheduleCallbackNormalPriority(noop).Named("echo"))
+	bot.RegisterWebhookEventCallback(ScheduleCallbackNormalPriority(noop).SerializeBy(SerializeUser, "drop"))
+	if len(bot.eventHandlers) != 1 || bot.registrationErr == nil {
+		t.Errorf("Expected a duplicate name and an unknown policy to be refused, got %d handlers", len(bot.eventHandlers))
+	}
+
+	if _, err := NewChatbotFromConfig(ServerConfig{Serialization: SerializationConfig{Key: "session"}}); err == nil {
+		t.Errorf("Expected an unknown serialization key to be rejected")
+	}
+}
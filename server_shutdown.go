@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// # Event Queue Draining
+//
+// Dispatch the events left in the queue, then wait for the running handlers.
+// Both steps stop at the deadline, whatever remains is reported as abandoned,
+// and the context of the running handlers is cancelled.
+//
+// The listener must be stopped before calling this, so that the queue no longer grows.
+func (tpb *TaipeionBot) drainEventQueue(deadline time.Time) {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	log.Printf("[Daemon] Draining %d queued events, deadline in %s.\n", tpb.eventQueue.Len(), time.Until(deadline).Round(time.Millisecond))
+
+	drained := 0
+drain:
+	for {
+		select {
+		case <-ctx.Done():
+			break drain
+		default:
+			queued, ok := tpb.eventQueue.TryDequeue()
+			if !ok { // Queue is empty.
+				break drain
+			}
+			tpb.dispatchEvent(queued)
+			drained++
+		}
+	}
+
+	// Wait for the running handlers.
+	handlers_done := make(chan struct{})
+	go func() {
+		tpb.runningHandlers.Wait()
+		close(handlers_done)
+	}()
+
+	select {
+	case <-handlers_done:
+	case <-ctx.Done():
+		log.Println("[Daemon] Shutdown deadline exceeded, cancelling running handlers.")
+	}
+	tpb.cancelHandlers()
+
+	log.Printf("[Daemon] Shutdown summary: %d events drained, %d queued events abandoned, %d running handlers abandoned.\n",
+		drained, tpb.eventQueue.Len(), atomic.LoadInt64(&tpb.runningCount))
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxSubsystemRestarts = 5
+	defaultRestartBackoffBase   = time.Second
+	defaultRestartBackoffMax    = time.Minute
+
+	subsystemStableRun = time.Minute // A run lasting longer than this resets the failure count.
+)
+
+// State of a supervised subsystem.
+type SubsystemState string
+
+const (
+	SubsystemRunning SubsystemState = "running" // The subsystem is running.
+	SubsystemBackoff SubsystemState = "backoff" // The subsystem has failed and waits for restart.
+	SubsystemStopped SubsystemState = "stopped" // The subsystem has been stopped on purpose.
+	SubsystemFailed  SubsystemState = "failed"  // The subsystem has failed too many times, supervisor gave up.
+)
+
+// A subsystem exited without error while it was expected to run.
+var ErrSubsystemExited = errors.New("subsystem exited unexpectedly")
+
+// # Subsystem Status
+//
+// A snapshot of the state of a supervised subsystem.
+type SubsystemStatus struct {
+	Name      string         `json:"name"`       // Name of the subsystem.
+	State     SubsystemState `json:"state"`      // Current state.
+	Failures  int            `json:"failures"`   // Consecutive failures.
+	Restarts  int            `json:"restarts"`   // Total restarts since start.
+	LastError string         `json:"last_error"` // The last error returned by the subsystem.
+}
+
+// A subsystem is a long running function, it should return only upon error or context cancellation.
+type subsystem struct {
+	name string
+	run  func(ctx context.Context) error
+
+	mu     sync.Mutex
+	status SubsystemStatus
+}
+
+// # Subsystem Supervisor
+//
+// Run subsystems and restart them with exponential backoff upon failure.
+// A subsystem failing more than `maxRestarts` times in a row is given up.
+type supervisor struct {
+	subsystems  []*subsystem
+	maxRestarts int           // Maximum consecutive restarts before giving up.
+	backoffBase time.Duration // Backoff before the first restart.
+	backoffMax  time.Duration // Upper bound of the backoff.
+	metrics     *MetricsRegistry
+}
+
+// # New Supervisor
+//
+// Create a supervisor, zero values fall back to defaults.
+func newSupervisor(maxRestarts int, backoffBase time.Duration, backoffMax time.Duration, metrics *MetricsRegistry) *supervisor {
+	if maxRestarts <= 0 {
+		maxRestarts = defaultMaxSubsystemRestarts
+	}
+	if backoffBase <= 0 {
+		backoffBase = defaultRestartBackoffBase
+	}
+	if backoffMax < backoffBase {
+		backoffMax = max(defaultRestartBackoffMax, backoffBase)
+	}
+	return &supervisor{
+		maxRestarts: maxRestarts,
+		backoffBase: backoffBase,
+		backoffMax:  backoffMax,
+		metrics:     metrics,
+	}
+}
+
+// Add a subsystem to the supervisor.
+func (s *supervisor) add(name string, run func(ctx context.Context) error) {
+	s.subsystems = append(s.subsystems, &subsystem{
+		name:   name,
+		run:    run,
+		status: SubsystemStatus{Name: name, State: SubsystemStopped},
+	})
+}
+
+// Update the state of a subsystem, and report it.
+func (s *supervisor) setState(sub *subsystem, state SubsystemState) {
+	sub.mu.Lock()
+	sub.status.State = state
+	sub.mu.Unlock()
+
+	up := int64(0)
+	if state == SubsystemRunning {
+		up = 1
+	}
+	s.metrics.Set(fmt.Sprintf("subsystem_%s_up", sub.name), up)
+	log.Printf("[Supervisor] Subsystem (%s) is %s.\n", sub.name, state)
+}
+
+// Backoff before the given restart attempt, counting from 1.
+func (s *supervisor) backoff(attempt int) time.Duration {
+	backoff := s.backoffBase
+	for i := 1; i < attempt && backoff < s.backoffMax; i++ {
+		backoff *= 2
+	}
+	return min(backoff, s.backoffMax)
+}
+
+// # Supervise a Subsystem
+//
+// Run the subsystem until the context is cancelled.
+// Returns an error if the supervisor gives up the subsystem.
+func (s *supervisor) supervise(ctx context.Context, sub *subsystem) error {
+	for {
+		started := time.Now()
+		s.setState(sub, SubsystemRunning)
+		err := sub.run(ctx)
+
+		if ctx.Err() != nil { // Stopped on purpose.
+			s.setState(sub, SubsystemStopped)
+			return nil
+		}
+
+		if err == nil {
+			err = ErrSubsystemExited
+		}
+
+		sub.mu.Lock()
+		if time.Since(started) >= subsystemStableRun {
+			sub.status.Failures = 0 // It was running fine for a while.
+		}
+		sub.status.Failures++
+		sub.status.LastError = err.Error()
+		failures := sub.status.Failures
+		sub.mu.Unlock()
+
+		log.Printf("[Supervisor] Subsystem (%s) failed (%d in a row, %d restarts allowed): %s\n", sub.name, failures, s.maxRestarts, err)
+
+		if failures > s.maxRestarts {
+			s.setState(sub, SubsystemFailed)
+			return fmt.Errorf("subsystem %s failed %d times in a row: %w", sub.name, failures, err)
+		}
+
+		// Wait before restarting.
+		s.setState(sub, SubsystemBackoff)
+		backoff := s.backoff(failures)
+		log.Printf("[Supervisor] Restarting subsystem (%s) in %s.\n", sub.name, backoff)
+
+		select {
+		case <-ctx.Done():
+			s.setState(sub, SubsystemStopped)
+			return nil
+		case <-time.After(backoff):
+		}
+
+		sub.mu.Lock()
+		sub.status.Restarts++
+		sub.mu.Unlock()
+		s.metrics.Inc(fmt.Sprintf("subsystem_%s_restarts_total", sub.name))
+	}
+}
+
+// # Run All Subsystems
+//
+// Supervise every subsystem until the context is cancelled or one of them is given up.
+// In the latter case all other subsystems are stopped, and the error is returned.
+func (s *supervisor) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	give_up := make(chan error, len(s.subsystems))
+	var wg sync.WaitGroup
+
+	for _, sub := range s.subsystems {
+		wg.Add(1)
+		go func(sub *subsystem) {
+			defer wg.Done()
+			if err := s.supervise(ctx, sub); err != nil {
+				give_up <- err
+				cancel() // Stop the other subsystems.
+			}
+		}(sub)
+	}
+
+	wg.Wait()
+
+	select {
+	case err := <-give_up:
+		return err
+	default:
+		return nil
+	}
+}
+
+// Take a snapshot of the status of every subsystem.
+func (s *supervisor) statuses() []SubsystemStatus {
+	statuses := make([]SubsystemStatus, 0, len(s.subsystems))
+	for _, sub := range s.subsystems {
+		sub.mu.Lock()
+		statuses = append(statuses, sub.status)
+		sub.mu.Unlock()
+	}
+	return statuses
+}
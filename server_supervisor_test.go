@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSupervisorBackoff(t *testing.T) {
+	s := newSupervisor(3, 100*time.Millisecond, 300*time.Millisecond, NewMetricsRegistry())
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i, e := range expected {
+		if b := s.backoff(i + 1); b != e {
+			t.Errorf("Attempt %d: expected backoff %s, got %s", i+1, e, b)
+		}
+	}
+}
+
+func TestSupervisorGivesUp(t *testing.T) {
+	s := newSupervisor(2, time.Millisecond, time.Millisecond, NewMetricsRegistry())
+
+	runs := 0
+	s.add("failing", func(ctx context.Context) error {
+		runs++
+		return errors.New("boom")
+	})
+
+	stopped := false
+	s.add("healthy", func(ctx context.Context) error {
+		<-ctx.Done()
+		stopped = true
+		return nil
+	})
+
+	if err := s.run(context.Background()); err == nil {
+		t.Fatalf("Expected the supervisor to give up")
+	}
+
+	if runs != 3 {
+		t.Errorf("Expected 3 runs (1 start, 2 restarts), got %d", runs)
+	}
+	if !stopped {
+		t.Errorf("Expected the healthy subsystem to be stopped")
+	}
+
+	statuses := s.statuses()
+	if statuses[0].State != SubsystemFailed || statuses[1].State != SubsystemStopped {
+		t.Errorf("Unexpected statuses: %#v", statuses)
+	}
+}
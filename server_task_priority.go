@@ -1,15 +1,132 @@
 package main
 
-func ScheduleCallbackNormalPriority(callback WebhookEventCallback) eventHandlerEntry {
+import (
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	tp "taipeion/core"
+)
+
+// # Schedule Callback with Priority
+//
+// Create a handler entry of the priority class, see `PriorityClassConfig`.
+func ScheduleCallbackWithPriority(callback WebhookEventCallback, priority string) eventHandlerEntry {
 	return eventHandlerEntry{
-		Callback:   callback,
-		IsPriority: false,
+		Callback: callback,
+		Name:     callbackName(callback),
+		Priority: priority,
 	}
 }
 
-func ScheduleCallbackHighestPriority(callback WebhookEventCallback) eventHandlerEntry {
+// # Schedule Context Callback with Priority
+func ScheduleContextCallbackWithPriority(callback ContextEventCallback, priority string) eventHandlerEntry {
 	return eventHandlerEntry{
-		Callback:   callback,
-		IsPriority: true,
+		ContextCallback: callback,
+		Name:            callbackName(callback),
+		Priority:        priority,
 	}
 }
+
+func ScheduleCallbackNormalPriority(callback WebhookEventCallback) eventHandlerEntry {
+	return ScheduleCallbackWithPriority(callback, PriorityNormal)
+}
+
+func ScheduleCallbackHighestPriority(callback WebhookEventCallback) eventHandlerEntry {
+	return ScheduleCallbackWithPriority(callback, PriorityHighest)
+}
+
+func ScheduleContextCallbackNormalPriority(callback ContextEventCallback) eventHandlerEntry {
+	return ScheduleContextCallbackWithPriority(callback, PriorityNormal)
+}
+
+func ScheduleContextCallbackHighestPriority(callback ContextEventCallback) eventHandlerEntry {
+	return ScheduleContextCallbackWithPriority(callback, PriorityHighest)
+}
+
+// Derive a short handler name from the function name, e.g. `LlmCallback`.
+func callbackName(callback any) string {
+	function := runtime.FuncForPC(reflect.ValueOf(callback).Pointer())
+	if function == nil {
+		return "handler"
+	}
+
+	name := function.Name()
+	name = name[strings.LastIndex(name, "/")+1:] // Strip the package path.
+	name = strings.TrimSuffix(name, "-fm")       // Method values.
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// Get the context-aware callback of the entry.
+func (entry eventHandlerEntry) contextCallback() ContextEventCallback {
+	if entry.ContextCallback != nil {
+		return entry.ContextCallback
+	}
+	return AdaptCallback(entry.Callback)
+}
+
+// # Name the Handler
+//
+// Return a copy of the entry with the name used in logs and metrics.
+// The name must be unique among the named handlers.
+func (entry eventHandlerEntry) Named(name string) eventHandlerEntry {
+	entry.Name = name
+	entry.explicitName = true
+	return entry
+}
+
+// # Set Priority
+//
+// Return a copy of the entry scheduled in the priority class.
+func (entry eventHandlerEntry) WithPriority(priority string) eventHandlerEntry {
+	entry.Priority = priority
+	return entry
+}
+
+// # Handler Deadline
+//
+// Return a copy of the entry with its own deadline, overriding the global handler timeout.
+func (entry eventHandlerEntry) WithTimeout(timeout time.Duration) eventHandlerEntry {
+	entry.Timeout = timeout
+	return entry
+}
+
+// # Handler Retry
+//
+// Return a copy of the entry whose failed calls are attempted up to `maxAttempts` times, including the first one.
+// Only opt in for handlers that are safe to call again, e.g. without side effects before the failure.
+func (entry eventHandlerEntry) WithRetry(maxAttempts int) eventHandlerEntry {
+	entry.MaxAttempts = maxAttempts
+	return entry
+}
+
+// # Add Middlewares
+//
+// Return a copy of the entry wrapped with the middlewares, inside of the middlewares already set.
+func (entry eventHandlerEntry) With(middlewares ...Middleware) eventHandlerEntry {
+	entry.Middlewares = append(slices.Clone(entry.Middlewares), middlewares...)
+	return entry
+}
+
+// # Add Matchers
+//
+// Return a copy of the entry called only on events matching all the matchers,
+// in addition to the matchers already set.
+func (entry eventHandlerEntry) When(matchers ...EventMatcher) eventHandlerEntry {
+	entry.Matchers = append(slices.Clone(entry.Matchers), matchers...)
+	return entry
+}
+
+// # Restrict to Event Types
+//
+// Return a copy of the entry handling only the given event types.
+func (entry eventHandlerEntry) ForEventTypes(eventTypes ...tp.EventType) eventHandlerEntry {
+	return entry.When(MatchEventTypes(eventTypes...))
+}
+
+// Check if the entry applies to the event.
+func (entry eventHandlerEntry) matches(bot *TaipeionBot, event ChatbotWebhookEvent) bool {
+	return MatchAll(entry.Matchers...)(bot, event)
+}
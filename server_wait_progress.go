@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const latencySmoothing = 0.2 // Weight of the latest call in the average latency of a handler.
+
+// # Wait Status
+//
+// The position of an event waiting for a handler slot.
+type WaitStatus struct {
+	Position int           // Estimated position in the queue, 1 is next.
+	Eta      time.Duration // Estimated wait from the recent latency of the handler, zero if unknown.
+	Waited   time.Duration // Time waited so far.
+	Update   bool          // Whether this is a progress update rather than the first notification.
+}
+
+// # Wait Notifier
+//
+// Called when the event has to wait for a handler slot, and again every `wait-progress-interval`
+// while it is still waiting. The handler is not running yet, the context is the one it will receive.
+type WaitNotifier func(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent, status WaitStatus)
+
+// # Notify Waiting Users
+//
+// Return a copy of the entry calling the notifier while events wait for a slot.
+func (entry eventHandlerEntry) WithWaitNotifier(notifier WaitNotifier) eventHandlerEntry {
+	entry.WaitNotifier = notifier
+	return entry
+}
+
+// # Latency Tracker
+//
+// Keep the exponential moving average of the successful call durations of each handler.
+type latencyTracker struct {
+	mu      sync.Mutex
+	average map[string]time.Duration
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{average: make(map[string]time.Duration)}
+}
+
+func (l *latencyTracker) record(handler string, duration time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if average, ok := l.average[handler]; ok {
+		l.average[handler] = time.Duration(latencySmoothing*float64(duration) + (1-latencySmoothing)*float64(average))
+	} else {
+		l.average[handler] = duration
+	}
+}
+
+// # Handler Latency
+//
+// Get the recent average duration of a handler call, false if the handler never succeeded.
+func (tpb *TaipeionBot) HandlerLatency(handler string) (time.Duration, bool) {
+	tpb.latency.mu.Lock()
+	defer tpb.latency.mu.Unlock()
+	average, ok := tpb.latency.average[handler]
+	return average, ok
+}
+
+// Build the position callback of the scheduler for the entry, nil if the entry has no notifier.
+func (tpb *TaipeionBot) waitNotifyFunc(ctx context.Context, entry eventHandlerEntry, event ChatbotWebhookEvent) func(position int, slots int) {
+	if entry.WaitNotifier == nil {
+		return nil
+	}
+
+	waiting_since := time.Now()
+	notified := false
+	return func(position int, slots int) {
+		status := WaitStatus{Position: position, Waited: time.Since(waiting_since), Update: notified}
+		if average, ok := tpb.HandlerLatency(entry.Name); ok {
+			// Every slot serves a waiter ahead per call.
+			status.Eta = time.Duration((position+slots-1)/slots) * average
+		}
+		notified = true
+
+		tpb.Metrics.Inc("handler_wait_notifications_total")
+		entry.WaitNotifier(ctx, tpb, event, status)
+	}
+}
+
+// Format a wait duration for users, e.g. "約 3 分鐘".
+func formatWaitDuration(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("約 %d 秒", max(int(d.Round(10*time.Second).Seconds()), 10))
+	}
+	if d < time.Hour {
+		return fmt.Sprintf("約 %d 分鐘", int(d.Round(time.Minute).Minutes()))
+	}
+	if d < 24*time.Hour {
+		return fmt.Sprintf("約 %d 小時", int(d.Round(time.Hour).Hours()))
+	}
+	return fmt.Sprintf("約 %d 天", int(d.Round(24*time.Hour).Hours()/24))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitNotifierPositionAndEta(t *testing.T) {
+	bot := NewChatbotInstance("", nil, "", 0, "", "", "", 1)
+	bot.waitProgressInterval = 20 * time.Millisecond
+	bot.latency.record("llm", time.Minute)
+
+	// Occupy the only slot.
+	release, err := bot.scheduler.acquire(context.Background(), PriorityNormal, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var statuses []WaitStatus
+	entry := ScheduleCallbackNormalPriority(func(bot *TaipeionBot, event ChatbotWebhookEvent) error {
+		return nil
+	}).Named("llm").WithWaitNotifier(func(ctx context.Context, bot *TaipeionBot, event ChatbotWebhookEvent, status WaitStatus) {
+		mu.Lock()
+		statuses = append(statuses, status)
+		mu.Unlock()
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- bot.eventProcessorInternalCallbackWrapper(bot.handlersCtx, entry, textEvent(1, "hello"))
+	}()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		mu.Lock()
+		notified := len(statuses) >= 2
+		mu.Unlock()
+		if notified {
+			break
+		}
+	}
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(statuses) < 2 {
+		t.Fatalf("Expected a notification and a progress update, got %#v", statuses)
+	}
+	if statuses[0].Position != 1 || statuses[0].Eta != time.Minute || statuses[0].Update {
+		t.Errorf("Unexpected first notification: %#v", statuses[0])
+	}
+	if !statuses[1].Update || statuses[1].Waited <= 0 {
+		t.Errorf("Unexpected progress update: %#v", statuses[1])
+	}
+}
+
+func TestSchedulerPosition(t *testing.T) {
+	s := newPriorityScheduler(1, nil, nil, nil)
+	release, _ := s.acquire(context.Background(), PriorityNormal, 1)
+	defer release()
+
+	positions := make(chan int, 4)
+	acquire := func(class string, channel int) {
+		go s.acquireWithUpdates(context.Background(), class, channel, 0, func(position int, slots int) { positions <- position })
+	}
+
+	acquire(PriorityNormal, 1)
+	if position := <-positions; position != 1 {
+		t.Errorf("Expected position 1, got %d", position)
+	}
+	acquire(PriorityNormal, 1)
+	if position := <-positions; position != 2 {
+		t.Errorf("Expected position 2, got %d", position)
+	}
+	// Channel 2 is served in turn with channel 1.
+	acquire(PriorityNormal, 2)
+	if position := <-positions; position != 2 {
+		t.Errorf("Expected position 2 on another channel, got %d", position)
+	}
+	// Higher priority classes are ahead of everyone.
+	acquire(PriorityLow, 1)
+	if position := <-positions; position != 4 {
+		t.Errorf("Expected position 4 in the low class, got %d", position)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultWebhookPath     = "/"           // Single route shared by all channels.
+	webhookPathChannelSlot = "{channelId}" // Placeholder of the channel ID in the webhook path.
+	anyChannel             = -1            // The route accepts events of any channel.
+)
+
+// # Webhook Route Resolver
+//
+// Resolve the webhook path of every channel.
+// A channel with its own `webhook-path` uses it as is, otherwise the server-wide path is used,
+// with `{channelId}` replaced by the channel's ID.
+//
+// Channels sharing a path without channel ID are mapped to `anyChannel`.
+func (tpb *TaipeionBot) webhookRoutes() (map[string]int, error) {
+	routes := make(map[string]int)
+
+	// Iterate in a stable order so that conflicts are reported consistently.
+	channel_ids := make([]int, 0, len(tpb.Channels))
+	for id := range tpb.Channels {
+		channel_ids = append(channel_ids, id)
+	}
+	sort.Ints(channel_ids)
+
+	for _, id := range channel_ids {
+		path := tpb.Channels[id].WebhookPath
+		if path == "" {
+			path = strings.ReplaceAll(tpb.webhookPath, webhookPathChannelSlot, strconv.Itoa(id))
+		}
+
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("webhook path of channel %d must start with '/': %q", id, path)
+		}
+
+		route_channel := id
+		if !strings.Contains(tpb.webhookPath, webhookPathChannelSlot) && tpb.Channels[id].WebhookPath == "" {
+			route_channel = anyChannel // Shared route, no cross-check possible.
+		}
+
+		if existing, ok := routes[path]; ok && (existing != anyChannel || route_channel != anyChannel) {
+			return nil, fmt.Errorf("webhook path %q is used by more than one channel", path)
+		}
+		routes[path] = route_channel
+	}
+
+	// Keep the legacy behaviour if no channel is configured.
+	if len(routes) == 0 {
+		routes[tpb.webhookPath] = anyChannel
+	}
+
+	return routes, nil
+}
+
+// # Webhook Serve Mux
+//
+// Build a new mux with a route for every channel.
+// Requests to unknown routes are logged and answered with 404.
+func (tpb *TaipeionBot) webhookServeMux() (*http.ServeMux, error) {
+	routes, err := tpb.webhookRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	for path, channel_id := range routes {
+		if channel_id == anyChannel {
+			log.Printf("[EvListener] Registering shared webhook route: %s\n", path)
+		} else {
+			log.Printf("[EvListener] Registering webhook route for channel (%d): %s\n", channel_id, path)
+		}
+		pattern := path
+		if pattern == "/" {
+			pattern = "/{$}" // Exact match, other paths are answered by the catch-all.
+		}
+		mux.HandleFunc(pattern, tpb.incomeRequestHandlerFactory(channel_id))
+	}
+
+	if tpb.metricsPath != "" {
+		if _, ok := routes[tpb.metricsPath]; ok {
+			return nil, fmt.Errorf("metrics path %q conflicts with a webhook path", tpb.metricsPath)
+		}
+		mux.HandleFunc(tpb.metricsPath, tpb.metricsHandler)
+	}
+
+	tpb.registerAdminRoutes(mux)
+
+	// Catch-all for unknown routes.
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("[EvListener] Request to unknown route %s %s from %s.\n", r.Method, r.URL.Path, r.RemoteAddr)
+		tpb.Metrics.Inc("webhook_unknown_route_total")
+		http.NotFound(w, r)
+	})
+
+	return mux, nil
+}
+
+// # Metrics Handler
+//
+// Serve the metrics snapshot as JSON.
+func (tpb *TaipeionBot) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tpb.Metrics.Snapshot())
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	tp "taipeion/core"
+)
+
+func TestWebhookPerChannelRoutes(t *testing.T) {
+	bot := NewChatbotInstance("", map[int]Channel{
+		1: {ChannelSecret: "secret-1"},
+		2: {ChannelSecret: "secret-2"},
+	}, "", 0, "", "", "", 1)
+	bot.webhookPath = "/webhook/{channelId}"
+	bot.eventQueue = newChannelEventQueue(10)
+
+	mux, err := bot.webhookServeMux()
+	if err != nil {
+		t.Fatalf("Error building mux: %v", err)
+	}
+
+	body := `{"destination":1,"events":[{"type":"message"}]}`
+
+	cases := []struct {
+		path      string
+		signature string
+		expected  int
+	}{
+		{"/webhook/1", tp.SignWebhookBody("secret-1", []byte(body)), http.StatusOK},
+		{"/webhook/2", tp.SignWebhookBody("secret-2", []byte(body)), http.StatusBadRequest}, // Destination mismatch.
+		{"/webhook/3", "", http.StatusNotFound},                                             // Unknown channel.
+		{"/webhook/1", "invalid", http.StatusUnauthorized},                                  // Bad signature.
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(body))
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		req.Header.Set(tp.DefaultWebhookSignatureHeader, c.signature)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d", c.path, c.expected, rec.Code)
+		}
+	}
+
+	if bot.eventQueue.Len() != 1 {
+		t.Errorf("Expected 1 queued event, got %d", bot.eventQueue.Len())
+	}
+}
+
+func TestWebhookRouteConflict(t *testing.T) {
+	bot := NewChatbotInstance("", map[int]Channel{
+		1: {WebhookPath: "/hook"},
+		2: {WebhookPath: "/hook"},
+	}, "", 0, "", "", "", 1)
+
+	if _, err := bot.webhookServeMux(); err == nil {
+		t.Errorf("Expected conflicting webhook paths to be rejected")
+	}
+}
+
+func TestWebhookDefaultRoute(t *testing.T) {
+	bot := NewChatbotInstance("", map[int]Channel{1: {ChannelSecret: "secret-1"}}, "", 0, "", "", "", 1)
+	bot.eventQueue = newChannelEventQueue(10)
+
+	mux, err := bot.webhookServeMux()
+	if err != nil {
+		t.Fatalf("Error building mux: %v", err)
+	}
+
+	body := `{"destination":1,"events":[{"type":"message"}]}`
+	for path, expected := range map[string]int{"/": http.StatusOK, "/unknown": http.StatusNotFound} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		req.Header.Set(tp.DefaultWebhookSignatureHeader, tp.SignWebhookBody("secret-1", []byte(body)))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != expected {
+			t.Errorf("%s: expected status %d, got %d", path, expected, rec.Code)
+		}
+	}
+}